@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/allenhutchison/podgrab/db"
+	"github.com/allenhutchison/podgrab/service"
+	"github.com/gin-gonic/gin"
+)
+
+func HealthCheck(c *gin.Context) {
+	if err := db.Ping(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"db": err.Error()})
+		return
+	}
+
+	setting := db.GetOrCreateSetting()
+	c.JSON(http.StatusOK, gin.H{
+		"db":              "ok",
+		"lastRefreshAt":   setting.LastRefreshAt,
+		"activeDownloads": service.ActiveDownloads(),
+	})
+}
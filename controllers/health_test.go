@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/allenhutchison/podgrab/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func performHealthCheck(t *testing.T) (int, map[string]interface{}) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/healthz", HealthCheck)
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/healthz", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	return w.Code, body
+}
+
+func TestHealthCheck(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	code, body := performHealthCheck(t)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body["db"])
+	assert.Nil(t, body["lastRefreshAt"])
+	assert.Equal(t, float64(0), body["activeDownloads"])
+
+	refreshedAt := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	require.NoError(t, db.UpdateLastRefreshAt(refreshedAt))
+	code, body = performHealthCheck(t)
+	assert.Equal(t, http.StatusOK, code)
+	lastRefreshAt, err := time.Parse(time.RFC3339, body["lastRefreshAt"].(string))
+	require.NoError(t, err)
+	assert.True(t, refreshedAt.Equal(lastRefreshAt))
+}
+
+func TestHealthCheckClosedDB(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	require.NoError(t, db.TeardownTestDB(testDB))
+
+	code, body := performHealthCheck(t)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.NotEqual(t, "ok", body["db"])
+}
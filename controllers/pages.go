@@ -33,6 +33,10 @@ type SettingModel struct {
 	BaseUrl                       string `form:"baseUrl" json:"baseUrl" query:"baseUrl"`
 	MaxDownloadConcurrency        int    `form:"maxDownloadConcurrency" json:"maxDownloadConcurrency" query:"maxDownloadConcurrency"`
 	UserAgent                     string `form:"userAgent" json:"userAgent" query:"userAgent"`
+	MaxNewEpisodesPerRefresh      int    `form:"maxNewEpisodesPerRefresh" json:"maxNewEpisodesPerRefresh" query:"maxNewEpisodesPerRefresh"`
+	MaxArchivePages               int    `form:"maxArchivePages" json:"maxArchivePages" query:"maxArchivePages"`
+	WriteID3Tags                  bool   `form:"writeID3Tags" json:"writeID3Tags" query:"writeID3Tags"`
+	DefaultPageSize               int    `form:"defaultPageSize" json:"defaultPageSize" query:"defaultPageSize"`
 }
 
 var searchOptions = map[string]string{
@@ -51,12 +51,26 @@ type PatchPodcastItem struct {
 	Title    string `form:"title" json:"title" query:"title"`
 }
 
+type MarkSeenData struct {
+	Ids []string `binding:"required" form:"ids" json:"ids"`
+}
+
 type AddPodcastData struct {
 	Url string `binding:"required" form:"url" json:"url"`
 }
 type AddTagData struct {
-	Label       string `binding:"required" form:"label" json:"label"`
-	Description string `form:"description" json:"description"`
+	Label          string `binding:"required" form:"label" json:"label"`
+	Description    string `form:"description" json:"description"`
+	AutoMarkPlayed bool   `form:"autoMarkPlayed" json:"autoMarkPlayed"`
+}
+
+type PatchPodcast struct {
+	FolderOverride     *string `form:"folderOverride" json:"folderOverride"`
+	FollowArchiveLinks *bool   `form:"followArchiveLinks" json:"followArchiveLinks"`
+}
+
+type PatchTag struct {
+	AutoMarkPlayed *bool `binding:"required" form:"autoMarkPlayed" json:"autoMarkPlayed"`
 }
 
 func GetAllPodcasts(c *gin.Context) {
@@ -264,11 +278,10 @@ func GetPodcastImageById(c *gin.Context) {
 
 		err := db.GetPodcastById(searchByIdQuery.Id, &podcast)
 		if err == nil {
-			localPath := service.GetPodcastLocalImagePath(podcast.Image, podcast.Title)
-			if _, err = os.Stat(localPath); os.IsNotExist(err) {
+			if podcast.LocalImageURL != podcast.Image || !service.FileExists(podcast.LocalImagePath) {
 				c.Redirect(302, podcast.Image)
 			} else {
-				c.File(localPath)
+				c.File(podcast.LocalImagePath)
 			}
 		}
 	} else {
@@ -349,6 +362,20 @@ func UnbookmarkPodcastItem(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 	}
 }
+func MarkPodcastItemsSeen(c *gin.Context) {
+	var input MarkSeenData
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.MarkPodcastItemsSeen(input.Ids); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Success"})
+}
+
 func PatchPodcastItemById(c *gin.Context) {
 	var searchByIdQuery SearchByIdQuery
 
@@ -577,7 +604,7 @@ func AddTag(c *gin.Context) {
 	var addTagData AddTagData
 	err := c.ShouldBindJSON(&addTagData)
 	if err == nil {
-		tag, err := service.AddTag(addTagData.Label, addTagData.Description)
+		tag, err := service.AddTag(addTagData.Label, addTagData.Description, addTagData.AutoMarkPlayed)
 		if err == nil {
 			c.JSON(200, tag)
 		} else {
@@ -594,6 +621,64 @@ func AddTag(c *gin.Context) {
 	}
 }
 
+func PatchPodcastById(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+	if c.ShouldBindUri(&searchByIdQuery) == nil {
+		var input PatchPodcast
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if input.FolderOverride == nil && input.FollowArchiveLinks == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		if input.FolderOverride != nil {
+			if err := service.SetPodcastFolderOverride(searchByIdQuery.Id, *input.FolderOverride); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+				return
+			}
+		}
+		if input.FollowArchiveLinks != nil {
+			if err := service.SetPodcastFollowArchiveLinks(searchByIdQuery.Id, *input.FollowArchiveLinks); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+				return
+			}
+		}
+		c.JSON(200, service.GetPodcastById(searchByIdQuery.Id))
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	}
+}
+
+func PatchTagById(c *gin.Context) {
+	var searchByIdQuery SearchByIdQuery
+	if c.ShouldBindUri(&searchByIdQuery) == nil {
+		tag, err := db.GetTagById(searchByIdQuery.Id)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+			return
+		}
+
+		var input PatchTag
+		if err := c.ShouldBindJSON(&input); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = db.UpdateTagAutoMarkPlayed(tag.ID, *input.AutoMarkPlayed)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		tag.AutoMarkPlayed = *input.AutoMarkPlayed
+		c.JSON(200, tag)
+	} else {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	}
+}
+
 func AddTagToPodcast(c *gin.Context) {
 	var addRemoveTagQuery AddRemoveTagQuery
 
@@ -629,7 +714,8 @@ func UpdateSetting(c *gin.Context) {
 		err = service.UpdateSettings(model.DownloadOnAdd, model.InitialDownloadCount,
 			model.AutoDownload, model.AppendDateToFileName, model.AppendEpisodeNumberToFileName,
 			model.DarkMode, model.DownloadEpisodeImages, model.GenerateNFOFile, model.DontDownloadDeletedFromDisk, model.BaseUrl,
-			model.MaxDownloadConcurrency, model.UserAgent,
+			model.MaxDownloadConcurrency, model.UserAgent, model.MaxNewEpisodesPerRefresh, model.MaxArchivePages, model.WriteID3Tags,
+			model.DefaultPageSize,
 		)
 		if err == nil {
 			c.JSON(200, gin.H{"message": "Success"})
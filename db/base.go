@@ -15,8 +15,11 @@ type Base struct {
 	DeletedAt *time.Time `gorm:"index"`
 }
 
-//BeforeCreate
+//BeforeCreate assigns a new id unless the caller set one, as when restoring a library
 func (base *Base) BeforeCreate(tx *gorm.DB) error {
+	if base.ID != "" {
+		return nil
+	}
 	tx.Statement.SetColumn("ID", uuid.NewV4().String())
 	return nil
 }
@@ -35,10 +35,19 @@ func Init() (*gorm.DB, error) {
 
 //Migrate Database
 func Migrate() {
-	DB.AutoMigrate(&Podcast{}, &PodcastItem{}, &Setting{}, &Migration{}, &JobLock{}, &Tag{})
+	DB.AutoMigrate(&Podcast{}, &PodcastItem{}, &Setting{}, &Migration{}, &JobLock{}, &Tag{}, &PodcastPerson{}, &PodcastFunding{})
 	RunMigrations()
 }
 
+// Ping checks that the database connection is still usable
+func Ping() error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Ping()
+}
+
 // Using this function to get a connection, you can create your connection pool here.
 func GetDB() *gorm.DB {
 	return DB
@@ -39,18 +39,20 @@ func GetAllPodcastItemsWithoutSize() (*[]PodcastItem, error) {
 	return &podcasts, result.Error
 }
 
+// getSortOrder always ends with the id so that items sharing a pub_date or duration keep
+// the same order between pages.
 func getSortOrder(sorting model.EpisodeSort) string {
 	switch sorting {
 	case model.RELEASE_ASC:
-		return "pub_date asc"
+		return "pub_date asc, id asc"
 	case model.RELEASE_DESC:
-		return "pub_date desc"
+		return "pub_date desc, id desc"
 	case model.DURATION_ASC:
-		return "duration asc"
+		return "duration asc, id asc"
 	case model.DURATION_DESC:
-		return "duration desc"
+		return "duration desc, id desc"
 	default:
-		return "pub_date desc"
+		return "pub_date desc, id desc"
 	}
 }
 
@@ -79,6 +81,17 @@ func GetPaginatedPodcastItemsNew(queryModel model.EpisodesFilter) (*[]PodcastIte
 		}
 	}
 
+	if queryModel.IsSeen != nil {
+		isSeen, err := strconv.ParseBool(*queryModel.IsSeen)
+		if err == nil {
+			if isSeen {
+				query = query.Where("is_seen=?", 1)
+			} else {
+				query = query.Where("is_seen=?", 0)
+			}
+		}
+	}
+
 	if queryModel.Q != "" {
 		query = query.Where("UPPER(title) like ?", "%"+strings.TrimSpace(strings.ToUpper(queryModel.Q))+"%")
 	}
@@ -91,10 +104,19 @@ func GetPaginatedPodcastItemsNew(queryModel model.EpisodesFilter) (*[]PodcastIte
 		query = query.Where("podcast_id in ?", queryModel.PodcastIds)
 	}
 
+	// Durations are in seconds; 0 means unknown and never satisfies a minimum
+	if queryModel.MinDuration != nil {
+		query = query.Where("duration>0 and duration>=?", *queryModel.MinDuration)
+	}
+	if queryModel.MaxDuration != nil {
+		query = query.Where("duration<=?", *queryModel.MaxDuration)
+	}
+
 	totalsQuery := query.Order(getSortOrder(queryModel.Sorting)).Find(&podcasts)
 	totalsQuery.Count(&total)
+	queryModel.ClampPage(total)
 
-	result := query.Limit(queryModel.Count).Offset((queryModel.Page - 1) * queryModel.Count).Order("pub_date desc").Find(&podcasts)
+	result := query.Limit(queryModel.Count).Offset((queryModel.Page - 1) * queryModel.Count).Order(getSortOrder(queryModel.Sorting)).Find(&podcasts)
 	return &podcasts, total, result.Error
 }
 
@@ -137,7 +159,7 @@ func GetPodcastById(id string, podcast *Podcast) error {
 
 	result := DB.Preload("PodcastItems", func(db *gorm.DB) *gorm.DB {
 		return db.Order("podcast_items.pub_date DESC")
-	}).First(&podcast, "id=?", id)
+	}).Preload("People").Preload("Funding").First(&podcast, "id=?", id)
 	return result.Error
 }
 
@@ -168,6 +190,23 @@ func GetAllPodcastItemsByPodcastId(podcastId string, podcastItems *[]PodcastItem
 	result := DB.Preload(clause.Associations).Where(&PodcastItem{PodcastID: podcastId}).Find(&podcastItems)
 	return result.Error
 }
+
+// GetNextUnplayedItem returns the oldest (or newest when ascending is false) downloaded
+// episode of a podcast that hasn't been played yet. gorm.ErrRecordNotFound means caught up.
+func GetNextUnplayedItem(podcastId string, ascending bool) (*PodcastItem, error) {
+	var podcastItem PodcastItem
+	sorting := model.RELEASE_DESC
+	if ascending {
+		sorting = model.RELEASE_ASC
+	}
+	result := DB.Preload(clause.Associations).
+		Where("podcast_id=?", podcastId).
+		Where("download_status=?", Downloaded).
+		Where("is_played=?", false).
+		Order(getSortOrder(sorting)).
+		First(&podcastItem)
+	return &podcastItem, result.Error
+}
 func GetAllPodcastItemsByPodcastIds(podcastIds []string, podcastItems *[]PodcastItem) error {
 
 	result := DB.Preload(clause.Associations).Where("podcast_id in ?", podcastIds).Order("pub_date desc").Find(&podcastItems)
@@ -191,14 +230,135 @@ func GetAllPodcastItemsByIds(podcastItemIds []string) (*[]PodcastItem, error) {
 }
 
 func SetAllEpisodesToDownload(podcastId string) error {
-	result := DB.Model(PodcastItem{}).Where(&PodcastItem{PodcastID: podcastId, DownloadStatus: Deleted}).Update("download_status", NotDownloaded)
-	return result.Error
+	return queueDeletedPodcastItems(DB, podcastId).Error
+}
+
+func queueDeletedPodcastItems(tx *gorm.DB, podcastId string) *gorm.DB {
+	return tx.Model(PodcastItem{}).Where(&PodcastItem{PodcastID: podcastId, DownloadStatus: Deleted}).Update("download_status", NotDownloaded)
+}
+
+// QueueAllPodcastItems queues every episode of the podcast that was skipped or
+// deleted so the next download run fetches it. Episodes already downloading
+// or downloaded are left alone.
+func QueueAllPodcastItems(podcastId string) (int, error) {
+	var count int64
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := queueDeletedPodcastItems(tx, podcastId)
+		count = result.RowsAffected
+		return result.Error
+	})
+	return int(count), err
+}
+
+// ResetAllPodcastItems undoes QueueAllPodcastItems by marking queued episodes
+// as Deleted. When includeDownloaded is set, downloaded episodes are reset as
+// well and the paths of their files returned for the caller to remove.
+// Episodes that are currently downloading are never touched.
+func ResetAllPodcastItems(podcastId string, includeDownloaded bool) (int, []string, error) {
+	var count int64
+	var paths []string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		statuses := []DownloadStatus{NotDownloaded}
+		if includeDownloaded {
+			var items []PodcastItem
+			if err := tx.Where("podcast_id=? and download_status=?", podcastId, Downloaded).Find(&items).Error; err != nil {
+				return err
+			}
+			for _, item := range items {
+				if item.DownloadPath != "" {
+					paths = append(paths, item.DownloadPath)
+				}
+			}
+			statuses = append(statuses, Downloaded)
+		}
+		result := tx.Model(PodcastItem{}).Where("podcast_id=? and download_status in (?)", podcastId, statuses).Updates(map[string]interface{}{
+			"download_status": Deleted,
+			"download_path":   "",
+			"download_date":   time.Time{},
+		})
+		count = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(count), paths, nil
 }
+
 func UpdateLastEpisodeDateForPodcast(podcastId string, lastEpisode time.Time) error {
 	result := DB.Model(Podcast{}).Where("id=?", podcastId).Update("last_episode", lastEpisode)
 	return result.Error
 }
 
+func UpdatePodcastFolderOverride(podcastId string, folderOverride string) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Update("folder_override", folderOverride)
+	return result.Error
+}
+
+func UpdatePodcastFollowArchiveLinks(podcastId string, followArchiveLinks bool) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Update("follow_archive_links", followArchiveLinks)
+	return result.Error
+}
+
+func UpdatePodcastURL(podcastId string, url string) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Update("url", url)
+	return result.Error
+}
+
+func UpdatePodcastImage(podcastId string, image string) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Update("image", image)
+	return result.Error
+}
+
+func UpdatePodcastLocalImage(podcastId string, localImagePath string, localImageURL string) error {
+	result := DB.Model(Podcast{}).Where("id=?", podcastId).Updates(map[string]interface{}{
+		"local_image_path": localImagePath,
+		"local_image_url":  localImageURL,
+	})
+	return result.Error
+}
+
+func GetPodcastPeopleAndFunding(podcastId string, people *[]PodcastPerson, funding *[]PodcastFunding) error {
+	if err := DB.Where("podcast_id=?", podcastId).Find(people).Error; err != nil {
+		return err
+	}
+	return DB.Where("podcast_id=?", podcastId).Find(funding).Error
+}
+
+func DeletePodcastPeopleAndFundingByPodcastId(podcastId string) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		return deletePodcastPeopleAndFunding(tx, podcastId)
+	})
+}
+
+func deletePodcastPeopleAndFunding(tx *gorm.DB, podcastId string) error {
+	if err := tx.Where("podcast_id=?", podcastId).Delete(&PodcastPerson{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("podcast_id=?", podcastId).Delete(&PodcastFunding{}).Error
+}
+
+func ReplacePodcastPeopleAndFunding(podcastId string, people []PodcastPerson, funding []PodcastFunding) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := deletePodcastPeopleAndFunding(tx, podcastId); err != nil {
+			return err
+		}
+		for _, person := range people {
+			person.PodcastID = podcastId
+			if err := tx.Create(&person).Error; err != nil {
+				return err
+			}
+		}
+		for _, fund := range funding {
+			fund.PodcastID = podcastId
+			if err := tx.Create(&fund).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func UpdatePodcastItemFileSize(podcastItemId string, size int64) error {
 	result := DB.Model(PodcastItem{}).Where("id=?", podcastItemId).Update("file_size", size)
 	return result.Error
@@ -217,6 +377,28 @@ func GetAllPodcastItemsToBeDownloaded() (*[]PodcastItem, error) {
 	//fmt.Println("To be downloaded : " + string(len(podcastItems)))
 	return &podcastItems, result.Error
 }
+func MarkPodcastItemsSeen(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	result := DB.Model(&PodcastItem{}).Where("id in ?", ids).Update("is_seen", true)
+	return result.Error
+}
+
+func RecordPodcastItemDownloadFailure(id string, downloadError string) error {
+	result := DB.Model(&PodcastItem{}).Where("id=?", id).Updates(map[string]interface{}{
+		"download_attempts":   gorm.Expr("download_attempts + 1"),
+		"last_download_error": downloadError,
+	})
+	return result.Error
+}
+
+func GetAllDownloadPaths() ([]string, error) {
+	var paths []string
+	result := DB.Model(&PodcastItem{}).Where("download_path != ''").Pluck("download_path", &paths)
+	return paths, result.Error
+}
+
 func GetAllPodcastItemsAlreadyDownloaded() (*[]PodcastItem, error) {
 	var podcastItems []PodcastItem
 	result := DB.Preload(clause.Associations).Where("download_status=?", Downloaded).Find(&podcastItems)
@@ -269,7 +451,11 @@ func GetEpisodeNumber(podcastItemId, podcastId string) (int, error) {
 }
 
 func ForceSetLastEpisodeDate(podcastId string) {
-	DB.Exec("update podcasts set last_episode = (select max(pi.pub_date) from podcast_items pi where pi.podcast_id = @id) where id = @id", sql.Named("id", podcastId))
+	forceSetLastEpisodeDate(DB, podcastId)
+}
+
+func forceSetLastEpisodeDate(tx *gorm.DB, podcastId string) error {
+	return tx.Exec("update podcasts set last_episode = (select max(pi.pub_date) from podcast_items pi where pi.podcast_id = @id) where id = @id", sql.Named("id", podcastId)).Error
 }
 
 func TogglePodcastPauseStatus(podcastId string, isPaused bool) error {
@@ -288,6 +474,10 @@ func GetPodcastItemByPodcastIdAndGUID(podcastId string, guid string, podcastItem
 	result := DB.Preload(clause.Associations).Where(&PodcastItem{PodcastID: podcastId, GUID: guid}).First(&podcastItem)
 	return result.Error
 }
+func GetPodcastItemWithoutGUIDByFileURL(podcastId string, fileURL string, podcastItem *PodcastItem) error {
+	result := DB.Preload(clause.Associations).Where("podcast_id=? and guid=? and file_url=?", podcastId, "", fileURL).First(&podcastItem)
+	return result.Error
+}
 func GetPodcastByTitleAndAuthor(title string, author string, podcast *Podcast) error {
 
 	result := DB.Preload(clause.Associations).Where(&Podcast{Title: title, Author: author}).First(&podcast)
@@ -299,6 +489,68 @@ func CreatePodcast(podcast *Podcast) error {
 	return tx.Error
 }
 
+// RestorePodcasts adds the podcasts and their PodcastItems in a single transaction.
+// Podcasts are matched by URL and items by GUID, or by FileURL when there is no GUID;
+// rows that already exist are left untouched. New rows keep their ID unless it is
+// already taken.
+func RestorePodcasts(podcasts []Podcast) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, restored := range podcasts {
+			items := restored.PodcastItems
+			restored.PodcastItems = nil
+
+			var podcast Podcast
+			err := tx.Where("url=?", restored.URL).First(&podcast).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				podcast = restored
+				if isIdTaken(tx, &Podcast{}, podcast.ID) {
+					podcast.ID = ""
+				}
+				err = tx.Create(&podcast).Error
+			}
+			if err != nil {
+				return err
+			}
+
+			for _, item := range items {
+				var existing PodcastItem
+				query := tx.Where("podcast_id=? and guid=?", podcast.ID, item.GUID)
+				if item.GUID == "" {
+					query = query.Where("file_url=?", item.FileURL)
+				}
+				err := query.First(&existing).Error
+				if err == nil {
+					continue
+				}
+				if !errors.Is(err, gorm.ErrRecordNotFound) {
+					return err
+				}
+
+				item.PodcastID = podcast.ID
+				if isIdTaken(tx, &PodcastItem{}, item.ID) {
+					item.ID = ""
+				}
+				if err := tx.Omit("Podcast").Create(&item).Error; err != nil {
+					return err
+				}
+			}
+			if err := forceSetLastEpisodeDate(tx, podcast.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func isIdTaken(tx *gorm.DB, model interface{}, id string) bool {
+	if id == "" {
+		return false
+	}
+	var count int64
+	tx.Model(model).Where("id=?", id).Count(&count)
+	return count > 0
+}
+
 func CreatePodcastItem(podcastItem *PodcastItem) error {
 	tx := DB.Omit("Podcast").Create(&podcastItem)
 	return tx.Error
@@ -326,6 +578,12 @@ func GetOrCreateSetting() *Setting {
 	return &setting
 }
 
+func UpdateLastRefreshAt(lastRefreshAt time.Time) error {
+	setting := GetOrCreateSetting()
+	result := DB.Model(&Setting{}).Where("id=?", setting.ID).Update("last_refresh_at", lastRefreshAt)
+	return result.Error
+}
+
 func GetLock(name string) *JobLock {
 	var jobLock JobLock
 	result := DB.Where("name = ?", name).First(&jobLock)
@@ -391,6 +649,17 @@ func GetAllTags(sorting string) (*[]Tag, error) {
 	return &tags, result.Error
 }
 
+func GetTagsWithPodcastCounts() (*[]TagWithCount, error) {
+	var tags []TagWithCount
+	result := DB.Table("tags").
+		Select("tags.*, count(podcast_tags.podcast_id) as podcast_count").
+		Joins("left join podcast_tags on podcast_tags.tag_id = tags.id").
+		Group("tags.id").
+		Order("tags.created_at").
+		Find(&tags)
+	return &tags, result.Error
+}
+
 func GetTagById(id string) (*Tag, error) {
 	var tag Tag
 	result := DB.Preload(clause.Associations).
@@ -404,6 +673,11 @@ func GetTagsByIds(ids []string) (*[]Tag, error) {
 
 	return &tag, result.Error
 }
+func GetTagsByPodcastId(podcastId string) (*[]Tag, error) {
+	var tags []Tag
+	result := DB.Joins("join podcast_tags on podcast_tags.tag_id = tags.id").Where("podcast_tags.podcast_id=?", podcastId).Find(&tags)
+	return &tags, result.Error
+}
 func GetTagByLabel(label string) (*Tag, error) {
 	var tag Tag
 	result := DB.Preload(clause.Associations).
@@ -420,6 +694,10 @@ func UpdateTag(tag *Tag) error {
 	tx := DB.Omit("Podcast").Save(&tag)
 	return tx.Error
 }
+func UpdateTagAutoMarkPlayed(id string, autoMarkPlayed bool) error {
+	result := DB.Model(Tag{}).Where("id=?", id).Update("auto_mark_played", autoMarkPlayed)
+	return result.Error
+}
 func AddTagToPodcast(id, tagId string) error {
 	tx := DB.Exec("INSERT INTO `podcast_tags` (`podcast_id`,`tag_id`) VALUES (?,?) ON CONFLICT DO NOTHING", id, tagId)
 	return tx.Error
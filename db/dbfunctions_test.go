@@ -1,11 +1,18 @@
 package db
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/allenhutchison/podgrab/model"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 )
 
 func TestGetPodcastByURL(t *testing.T) {
@@ -111,27 +118,27 @@ func TestGetSortOrder(t *testing.T) {
 		{
 			name:     "release ascending",
 			sorting:  model.RELEASE_ASC,
-			expected: "pub_date asc",
+			expected: "pub_date asc, id asc",
 		},
 		{
 			name:     "release descending",
 			sorting:  model.RELEASE_DESC,
-			expected: "pub_date desc",
+			expected: "pub_date desc, id desc",
 		},
 		{
 			name:     "duration ascending",
 			sorting:  model.DURATION_ASC,
-			expected: "duration asc",
+			expected: "duration asc, id asc",
 		},
 		{
 			name:     "duration descending",
 			sorting:  model.DURATION_DESC,
-			expected: "duration desc",
+			expected: "duration desc, id desc",
 		},
 		{
 			name:     "default/empty",
 			sorting:  "",
-			expected: "pub_date desc",
+			expected: "pub_date desc, id desc",
 		},
 	}
 
@@ -319,7 +326,447 @@ func TestGetPodcastsByURLList(t *testing.T) {
 	assert.Len(t, podcasts, 1, "GetPodcastsByURLList only returns 1 result due to using First() instead of Find()")
 }
 
+func TestGetNextUnplayedItem(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	podcast, err := CreateTestPodcast(db, "Test Podcast")
+	require.NoError(t, err)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	items := []struct {
+		title    string
+		status   DownloadStatus
+		isPlayed bool
+	}{
+		{"Episode 1", Downloaded, true},
+		{"Episode 2", Downloaded, false},
+		{"Episode 3", NotDownloaded, false},
+		{"Episode 4", Downloaded, false},
+		{"Episode 5", Downloaded, true},
+		{"Episode 6", Deleted, false},
+	}
+	for i, it := range items {
+		item, err := CreateTestPodcastItem(db, podcast, it.title, it.status)
+		require.NoError(t, err)
+		item.PubDate = base.AddDate(0, 0, i)
+		item.IsPlayed = it.isPlayed
+		require.NoError(t, db.Save(item).Error)
+	}
+
+	t.Run("ascending returns earliest unplayed downloaded item", func(t *testing.T) {
+		item, err := GetNextUnplayedItem(podcast.ID, true)
+		assert.NoError(t, err)
+		assert.Equal(t, "Episode 2", item.Title)
+	})
+
+	t.Run("descending returns latest unplayed downloaded item", func(t *testing.T) {
+		item, err := GetNextUnplayedItem(podcast.ID, false)
+		assert.NoError(t, err)
+		assert.Equal(t, "Episode 4", item.Title)
+	})
+
+	t.Run("episodes sharing a date are ordered by id", func(t *testing.T) {
+		sameDay, err := CreateTestPodcast(db, "Same Day Podcast")
+		require.NoError(t, err)
+		var ids []string
+		for _, title := range []string{"Part A", "Part B", "Part C"} {
+			item, err := CreateTestPodcastItem(db, sameDay, title, Downloaded)
+			require.NoError(t, err)
+			item.PubDate = base
+			require.NoError(t, db.Save(item).Error)
+			ids = append(ids, item.ID)
+		}
+		sort.Strings(ids)
+
+		for i := 0; i < 5; i++ {
+			item, err := GetNextUnplayedItem(sameDay.ID, true)
+			require.NoError(t, err)
+			assert.Equal(t, ids[0], item.ID)
+
+			item, err = GetNextUnplayedItem(sameDay.ID, false)
+			require.NoError(t, err)
+			assert.Equal(t, ids[len(ids)-1], item.ID)
+		}
+	})
+
+	t.Run("caught up returns not found", func(t *testing.T) {
+		require.NoError(t, db.Model(&PodcastItem{}).Where("podcast_id=?", podcast.ID).Update("is_played", true).Error)
+		_, err := GetNextUnplayedItem(podcast.ID, true)
+		assert.True(t, errors.Is(err, gorm.ErrRecordNotFound))
+	})
+}
+
+func TestGetTagsWithPodcastCounts(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	podcastA, err := CreateTestPodcast(db, "Podcast A")
+	require.NoError(t, err)
+	podcastB, err := CreateTestPodcast(db, "Podcast B")
+	require.NoError(t, err)
+	podcastC, err := CreateTestPodcast(db, "Podcast C")
+	require.NoError(t, err)
+
+	empty, err := CreateTestTag(db, "Empty")
+	require.NoError(t, err)
+	single, err := CreateTestTag(db, "Single")
+	require.NoError(t, err)
+	multiple, err := CreateTestTag(db, "Multiple")
+	require.NoError(t, err)
+
+	require.NoError(t, AddTagToPodcast(podcastA.ID, single.ID))
+	require.NoError(t, AddTagToPodcast(podcastA.ID, multiple.ID))
+	require.NoError(t, AddTagToPodcast(podcastB.ID, multiple.ID))
+	require.NoError(t, AddTagToPodcast(podcastC.ID, multiple.ID))
+
+	tags, err := GetTagsWithPodcastCounts()
+	assert.NoError(t, err)
+	assert.Len(t, *tags, 3)
+
+	counts := make(map[string]int)
+	for _, tag := range *tags {
+		counts[tag.ID] = tag.PodcastCount
+		assert.NotEmpty(t, tag.Label)
+	}
+	assert.Equal(t, 0, counts[empty.ID])
+	assert.Equal(t, 1, counts[single.ID])
+	assert.Equal(t, 3, counts[multiple.ID])
+}
+
+func TestGetPaginatedPodcastItemsNewClampsPage(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	podcast, err := CreateTestPodcast(db, "Test Podcast")
+	require.NoError(t, err)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		item, err := CreateTestPodcastItem(db, podcast, fmt.Sprintf("Episode %d", i+1), Downloaded)
+		require.NoError(t, err)
+		item.PubDate = base.AddDate(0, 0, i)
+		require.NoError(t, db.Save(item).Error)
+	}
+
+	filter := model.EpisodesFilter{
+		Pagination: model.Pagination{
+			Page:  99,
+			Count: 2,
+		},
+		Sorting: model.RELEASE_DESC,
+	}
+	items, total, err := GetPaginatedPodcastItemsNew(filter)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), total)
+	require.Len(t, *items, 1)
+	assert.Equal(t, "Episode 1", (*items)[0].Title)
+
+	filter.SetCounts(total)
+	assert.Equal(t, 3, filter.Page)
+	assert.Equal(t, 0, filter.NextPage)
+	assert.Equal(t, 2, filter.PreviousPage)
+}
+
+func TestGetPaginatedPodcastItemsNewStableOrder(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	podcast, err := CreateTestPodcast(db, "Test Podcast")
+	require.NoError(t, err)
+
+	pubDate := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		item, err := CreateTestPodcastItem(db, podcast, fmt.Sprintf("Episode %d", i+1), Downloaded)
+		require.NoError(t, err)
+		item.PubDate = pubDate
+		require.NoError(t, db.Save(item).Error)
+	}
+
+	for _, sorting := range []model.EpisodeSort{model.RELEASE_DESC, model.RELEASE_ASC, model.DURATION_ASC, model.DURATION_DESC} {
+		t.Run(string(sorting), func(t *testing.T) {
+			seen := make(map[string]int)
+			for page := 1; page <= 2; page++ {
+				items, total, err := GetPaginatedPodcastItemsNew(model.EpisodesFilter{
+					Pagination: model.Pagination{
+						Page:  page,
+						Count: 3,
+					},
+					Sorting: sorting,
+				})
+				require.NoError(t, err)
+				assert.Equal(t, int64(6), total)
+				assert.Len(t, *items, 3)
+				for _, item := range *items {
+					seen[item.ID]++
+				}
+			}
+			assert.Len(t, seen, 6)
+			for id, count := range seen {
+				assert.Equal(t, 1, count, "item %s returned more than once", id)
+			}
+		})
+	}
+}
+
+func TestQueueAndResetAllPodcastItems(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	podcast, err := CreateTestPodcast(db, "Test Podcast")
+	require.NoError(t, err)
+	other, err := CreateTestPodcast(db, "Other Podcast")
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		_, err := CreateTestPodcastItem(db, podcast, fmt.Sprintf("Deleted %d", i), Deleted)
+		require.NoError(t, err)
+	}
+	queued, err := CreateTestPodcastItem(db, podcast, "Queued", NotDownloaded)
+	require.NoError(t, err)
+	downloading, err := CreateTestPodcastItem(db, podcast, "Downloading", Downloading)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "downloaded.mp3")
+	require.NoError(t, ioutil.WriteFile(file, []byte("audio"), 0644))
+	downloaded, err := CreateTestPodcastItem(db, podcast, "Downloaded", Downloaded)
+	require.NoError(t, err)
+	downloaded.DownloadPath = file
+	require.NoError(t, db.Save(downloaded).Error)
+
+	otherItem, err := CreateTestPodcastItem(db, other, "Other", Deleted)
+	require.NoError(t, err)
+
+	statusOf := func(id string) DownloadStatus {
+		var item PodcastItem
+		require.NoError(t, GetPodcastItemById(id, &item))
+		return item.DownloadStatus
+	}
+
+	count, err := QueueAllPodcastItems(podcast.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, Downloading, statusOf(downloading.ID))
+	assert.Equal(t, Downloaded, statusOf(downloaded.ID))
+	assert.Equal(t, Deleted, statusOf(otherItem.ID))
+
+	count, paths, err := ResetAllPodcastItems(podcast.ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Empty(t, paths)
+	assert.Equal(t, Deleted, statusOf(queued.ID))
+	assert.Equal(t, Downloading, statusOf(downloading.ID))
+	assert.Equal(t, Downloaded, statusOf(downloaded.ID))
+
+	count, paths, err = ResetAllPodcastItems(podcast.ID, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []string{file}, paths)
+	assert.Equal(t, Deleted, statusOf(downloaded.ID))
+	assert.Equal(t, Downloading, statusOf(downloading.ID))
+	assert.FileExists(t, file, "files are left to the caller")
+}
+
+func TestGetPaginatedPodcastItemsNewScopedSearch(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	target, err := CreateTestPodcast(db, "Target Podcast")
+	require.NoError(t, err)
+	other, err := CreateTestPodcast(db, "Other Podcast")
+	require.NoError(t, err)
+
+	for _, title := range []string{"Interview One", "Interview Two", "The Big Interview", "Mailbag"} {
+		_, err := CreateTestPodcastItem(db, target, title, Downloaded)
+		require.NoError(t, err)
+	}
+	for _, title := range []string{"Interview Three", "Another interview"} {
+		_, err := CreateTestPodcastItem(db, other, title, Downloaded)
+		require.NoError(t, err)
+	}
+
+	filter := model.EpisodesFilter{
+		Pagination: model.Pagination{
+			Page:  1,
+			Count: 2,
+		},
+		Sorting:    model.RELEASE_DESC,
+		Q:          "interview",
+		PodcastIds: []string{target.ID},
+	}
+
+	var titles []string
+	for page := 1; page <= 2; page++ {
+		filter.Page = page
+		items, total, err := GetPaginatedPodcastItemsNew(filter)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), total)
+		for _, item := range *items {
+			assert.Equal(t, target.ID, item.PodcastID)
+			titles = append(titles, item.Title)
+		}
+	}
+	assert.ElementsMatch(t, []string{"Interview One", "Interview Two", "The Big Interview"}, titles)
+
+	t.Run("without podcast ids the whole library is searched", func(t *testing.T) {
+		filter.Page = 1
+		filter.PodcastIds = nil
+		_, total, err := GetPaginatedPodcastItemsNew(filter)
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), total)
+	})
+}
+
+func TestMarkPodcastItemsSeenAndFilter(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	podcast, err := CreateTestPodcast(db, "Test Podcast")
+	require.NoError(t, err)
+
+	var items []*PodcastItem
+	for i := 0; i < 4; i++ {
+		item, err := CreateTestPodcastItem(db, podcast, fmt.Sprintf("Episode %d", i+1), Downloaded)
+		require.NoError(t, err)
+		assert.False(t, item.IsSeen, "new items start unseen")
+		items = append(items, item)
+	}
+	// Playing an episode does not mark it seen
+	require.NoError(t, db.Model(items[3]).Update("is_played", true).Error)
+
+	require.NoError(t, MarkPodcastItemsSeen([]string{items[0].ID, items[1].ID}))
+	require.NoError(t, MarkPodcastItemsSeen(nil))
+
+	count := func(isSeen string) int64 {
+		filter := model.EpisodesFilter{
+			Pagination: model.Pagination{Page: 1, Count: 10},
+			Sorting:    model.RELEASE_DESC,
+			IsSeen:     &isSeen,
+		}
+		_, total, err := GetPaginatedPodcastItemsNew(filter)
+		require.NoError(t, err)
+		return total
+	}
+	assert.Equal(t, int64(2), count("true"))
+	assert.Equal(t, int64(2), count("false"))
+
+	var played PodcastItem
+	require.NoError(t, GetPodcastItemById(items[3].ID, &played))
+	assert.True(t, played.IsPlayed)
+	assert.False(t, played.IsSeen)
+}
+
+func TestGetPaginatedPodcastItemsNewDurationRange(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	first, err := CreateTestPodcast(db, "First Podcast")
+	require.NoError(t, err)
+	second, err := CreateTestPodcast(db, "Second Podcast")
+	require.NoError(t, err)
+
+	durations := map[string]int{
+		"Unknown":   0,
+		"Short":     300,
+		"Commute":   1200,
+		"Exact Max": 1800,
+		"Long":      3600,
+	}
+	for title, duration := range durations {
+		for _, podcast := range []*Podcast{first, second} {
+			item, err := CreateTestPodcastItem(db, podcast, podcast.Title+" "+title, NotDownloaded)
+			require.NoError(t, err)
+			item.Duration = duration
+			require.NoError(t, db.Save(item).Error)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		min      *int
+		max      *int
+		expected []string
+	}{
+		{"no bounds", nil, nil, []string{"Unknown", "Short", "Commute", "Exact Max", "Long"}},
+		{"min only excludes unknown", intPtr(1), nil, []string{"Short", "Commute", "Exact Max", "Long"}},
+		{"max only keeps unknown", nil, intPtr(600), []string{"Unknown", "Short"}},
+		{"bounds are inclusive", intPtr(1200), intPtr(1800), []string{"Commute", "Exact Max"}},
+		{"zero min still excludes unknown", intPtr(0), intPtr(1800), []string{"Short", "Commute", "Exact Max"}},
+		{"empty range", intPtr(2000), intPtr(3000), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := model.EpisodesFilter{
+				Pagination:  model.Pagination{Page: 1, Count: 4},
+				Sorting:     model.DURATION_ASC,
+				MinDuration: tt.min,
+				MaxDuration: tt.max,
+			}
+
+			var found []int
+			for {
+				items, total, err := GetPaginatedPodcastItemsNew(filter)
+				require.NoError(t, err)
+				assert.Equal(t, int64(2*len(tt.expected)), total)
+				for _, item := range *items {
+					found = append(found, item.Duration)
+				}
+				if int64(filter.Page*filter.Count) >= total {
+					break
+				}
+				filter.Page++
+			}
+
+			var expected []int
+			for _, title := range tt.expected {
+				expected = append(expected, durations[title], durations[title])
+			}
+			assert.ElementsMatch(t, expected, found)
+		})
+	}
+}
+
+func TestDeletePodcastPeopleAndFundingByPodcastId(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	podcast, err := CreateTestPodcast(db, "Test Podcast")
+	require.NoError(t, err)
+	other, err := CreateTestPodcast(db, "Other Podcast")
+	require.NoError(t, err)
+	for _, p := range []*Podcast{podcast, other} {
+		require.NoError(t, ReplacePodcastPeopleAndFunding(p.ID,
+			[]PodcastPerson{{Name: "Host", Role: "host"}},
+			[]PodcastFunding{{URL: "https://example.com/support", Text: "Support"}}))
+	}
+
+	require.NoError(t, DeletePodcastPeopleAndFundingByPodcastId(podcast.ID))
+
+	var people []PodcastPerson
+	var funding []PodcastFunding
+	require.NoError(t, GetPodcastPeopleAndFunding(podcast.ID, &people, &funding))
+	assert.Empty(t, people)
+	assert.Empty(t, funding)
+
+	require.NoError(t, GetPodcastPeopleAndFunding(other.ID, &people, &funding))
+	assert.Len(t, people, 1)
+	assert.Len(t, funding, 1)
+}
+
 // Helper function to create string pointers
 func stringPtr(s string) *string {
 	return &s
 }
+
+func intPtr(i int) *int {
+	return &i
+}
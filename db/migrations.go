@@ -18,6 +18,10 @@ var migrations = []localMigration{
 		Name:  "2020_11_03_04_42_SetDefaultDownloadStatus",
 		Query: "update podcast_items set download_status=2 where download_path!='' and download_status=0",
 	},
+	{
+		Name:  "2026_10_16_12_00_MarkExistingItemsSeen",
+		Query: "update podcast_items set is_seen=1",
+	},
 }
 
 func RunMigrations() {
@@ -26,18 +30,32 @@ func RunMigrations() {
 	}
 }
 func ExecuteAndSaveMigration(name string, query string) error {
-	var migration Migration
-	result := DB.Where("name=?", name).First(&migration)
-	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	return RunMigration(name, func(tx *gorm.DB) error {
 		fmt.Println(query)
-		result = DB.Debug().Exec(query)
-		if result.Error == nil {
-			DB.Save(&Migration{
-				Date: time.Now(),
-				Name: name,
-			})
-		}
+		return tx.Debug().Exec(query).Error
+	})
+}
+
+// RunMigration runs fn once for the given key. fn and the record of the key are
+// committed in a single transaction, so a failed migration is retried on the next
+// start and a successful one is never run again.
+func RunMigration(key string, fn func(tx *gorm.DB) error) error {
+	var migration Migration
+	result := DB.Where("name=?", key).First(&migration)
+	if result.Error == nil {
+		return nil
+	}
+	if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
 		return result.Error
 	}
-	return nil
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.Create(&Migration{
+			Date: time.Now(),
+			Name: key,
+		}).Error
+	})
 }
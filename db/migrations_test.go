@@ -0,0 +1,88 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestRunMigration(t *testing.T) {
+	db, err := SetupTestDB()
+	require.NoError(t, err)
+	defer TeardownTestDB(db)
+
+	t.Run("runs once across two calls", func(t *testing.T) {
+		calls := 0
+		fn := func(tx *gorm.DB) error {
+			calls++
+			return nil
+		}
+
+		require.NoError(t, RunMigration("test_runs_once", fn))
+		require.NoError(t, RunMigration("test_runs_once", fn))
+		assert.Equal(t, 1, calls)
+
+		var count int64
+		db.Model(&Migration{}).Where("name=?", "test_runs_once").Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("skips already recorded key", func(t *testing.T) {
+		require.NoError(t, db.Create(&Migration{Date: time.Now(), Name: "test_recorded"}).Error)
+
+		calls := 0
+		require.NoError(t, RunMigration("test_recorded", func(tx *gorm.DB) error {
+			calls++
+			return nil
+		}))
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("failure rolls back and is retried", func(t *testing.T) {
+		podcast, err := CreateTestPodcast(db, "Migration Podcast")
+		require.NoError(t, err)
+
+		failure := errors.New("migration failed")
+		err = RunMigration("test_failure", func(tx *gorm.DB) error {
+			if err := tx.Model(&Podcast{}).Where("id=?", podcast.ID).Update("title", "Changed").Error; err != nil {
+				return err
+			}
+			return failure
+		})
+		assert.ErrorIs(t, err, failure)
+
+		var saved Podcast
+		require.NoError(t, db.First(&saved, "id=?", podcast.ID).Error)
+		assert.Equal(t, "Migration Podcast", saved.Title)
+
+		calls := 0
+		require.NoError(t, RunMigration("test_failure", func(tx *gorm.DB) error {
+			calls++
+			return nil
+		}))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("sql migrations use the runner", func(t *testing.T) {
+		podcast, err := CreateTestPodcast(db, "SQL Podcast")
+		require.NoError(t, err)
+		item, err := CreateTestPodcastItem(db, podcast, "Episode", NotDownloaded)
+		require.NoError(t, err)
+		require.NoError(t, db.Model(item).Update("download_path", "/data/episode.mp3").Error)
+
+		RunMigrations()
+		RunMigrations()
+
+		var saved PodcastItem
+		require.NoError(t, db.First(&saved, "id=?", item.ID).Error)
+		assert.Equal(t, Downloaded, saved.DownloadStatus)
+
+		var count int64
+		db.Model(&Migration{}).Where("name=?", migrations[0].Name).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+}
@@ -15,6 +15,9 @@ type Podcast struct {
 
 	Image string
 
+	LocalImagePath string
+	LocalImageURL  string
+
 	URL string
 
 	LastEpisode *time.Time
@@ -23,6 +26,9 @@ type Podcast struct {
 
 	Tags []*Tag `gorm:"many2many:podcast_tags;"`
 
+	People  []PodcastPerson
+	Funding []PodcastFunding
+
 	DownloadedEpisodesCount  int `gorm:"-"`
 	DownloadingEpisodesCount int `gorm:"-"`
 	AllEpisodesCount         int `gorm:"-"`
@@ -32,6 +38,10 @@ type Podcast struct {
 	AllEpisodesSize         int64 `gorm:"-"`
 
 	IsPaused bool `gorm:"default:false"`
+
+	FolderOverride string
+
+	FollowArchiveLinks bool `gorm:"default:false"`
 }
 
 //PodcastItem is
@@ -59,11 +69,36 @@ type PodcastItem struct {
 
 	IsPlayed bool `gorm:"default:false"`
 
+	IsSeen bool `gorm:"default:false"`
+
 	BookmarkDate time.Time
 
 	LocalImage string
 
 	FileSize int64
+
+	DownloadAttempts int `gorm:"default:0"`
+
+	LastDownloadError string
+}
+
+//PodcastPerson is a podcast:person entry from the feed
+type PodcastPerson struct {
+	Base
+	PodcastID string
+	Name      string
+	Role      string
+	Group     string
+	Href      string
+	Img       string
+}
+
+//PodcastFunding is a podcast:funding entry from the feed
+type PodcastFunding struct {
+	Base
+	PodcastID string
+	URL       string
+	Text      string
 }
 
 type DownloadStatus int
@@ -89,6 +124,11 @@ type Setting struct {
 	BaseUrl                       string
 	MaxDownloadConcurrency        int `gorm:"default:5"`
 	UserAgent                     string
+	MaxNewEpisodesPerRefresh      int `gorm:"default:0"`
+	MaxArchivePages               int `gorm:"default:10"`
+	LastRefreshAt                 *time.Time
+	WriteID3Tags                  bool `gorm:"default:false"`
+	DefaultPageSize               int  `gorm:"default:20"`
 }
 type Migration struct {
 	Base
@@ -108,6 +148,13 @@ type Tag struct {
 	Label       string
 	Description string     `gorm:"type:text"`
 	Podcasts    []*Podcast `gorm:"many2many:podcast_tags;"`
+
+	AutoMarkPlayed bool `gorm:"default:false"`
+}
+
+type TagWithCount struct {
+	Tag
+	PodcastCount int
 }
 
 func (lock *JobLock) IsLocked() bool {
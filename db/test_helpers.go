@@ -15,7 +15,7 @@ func SetupTestDB() (*gorm.DB, error) {
 	}
 
 	// Run migrations
-	err = db.AutoMigrate(&Podcast{}, &PodcastItem{}, &Setting{}, &Migration{}, &JobLock{}, &Tag{})
+	err = db.AutoMigrate(&Podcast{}, &PodcastItem{}, &Setting{}, &Migration{}, &JobLock{}, &Tag{}, &PodcastPerson{}, &PodcastFunding{})
 	if err != nil {
 		return nil, err
 	}
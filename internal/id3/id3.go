@@ -0,0 +1,385 @@
+// Package id3 reads and writes the small subset of ID3v2 tags podgrab needs.
+package id3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	headerSize = 10
+	frameSize  = 10
+
+	encodingISO88591 = 0
+	encodingUTF16    = 1
+	encodingUTF16BE  = 2
+	encodingUTF8     = 3
+
+	pictureTypeFrontCover = 3
+
+	flagUnsynchronisation = 0x80
+	flagExtendedHeader    = 0x40
+	flagFooter            = 0x10
+)
+
+// writtenFrames are the frames WriteFile owns. Any other frame in an existing tag is kept.
+var writtenFrames = map[string]bool{
+	"TIT2": true,
+	"TPE1": true,
+	"TALB": true,
+	"TRCK": true,
+	"TYER": true,
+	"TDRC": true,
+	"APIC": true,
+}
+
+// Tag holds the fields written to a file. Empty fields are left out.
+type Tag struct {
+	Title   string
+	Artist  string
+	Album   string
+	Track   string
+	Year    string
+	Picture []byte
+}
+
+type rawTag struct {
+	version byte
+	flags   byte
+	size    int64 // header, body and footer
+	body    []byte
+}
+
+type frame struct {
+	id    string
+	flags [2]byte
+	data  []byte
+}
+
+// WriteFile replaces the fields of Tag in the ID3v2 tag at the start of the file,
+// keeping any other frames (chapters, comments, ...) and leaving the audio data
+// untouched. The file is rewritten through a temporary file so a failure never
+// leaves it half written.
+func WriteFile(path string, tag Tag) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	existing, err := readTag(src, info.Size())
+	if err != nil {
+		return err
+	}
+
+	version := byte(3)
+	var audioOffset int64
+	var kept []frame
+	if existing != nil {
+		audioOffset = existing.size
+		if existing.version == 3 || existing.version == 4 {
+			version = existing.version
+			// A damaged tag still gets replaced; only the frames read before the damage survive
+			frames, _ := parseFrames(existing)
+			for _, f := range frames {
+				if !writtenFrames[f.id] {
+					kept = append(kept, f)
+				}
+			}
+		}
+	}
+	if _, err := src.Seek(audioOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".id3-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(encode(tag, version, kept)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// ReadFile reads the fields of Tag from the ID3v2 tag at the start of the file.
+func ReadFile(path string) (Tag, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Tag{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return Tag{}, err
+	}
+	existing, err := readTag(file, info.Size())
+	if err != nil {
+		return Tag{}, err
+	}
+	if existing == nil {
+		return Tag{}, errors.New("no ID3v2 tag found")
+	}
+
+	var tag Tag
+	frames, err := parseFrames(existing)
+	for _, f := range frames {
+		switch f.id {
+		case "TIT2":
+			tag.Title = decodeText(f.data)
+		case "TPE1":
+			tag.Artist = decodeText(f.data)
+		case "TALB":
+			tag.Album = decodeText(f.data)
+		case "TRCK":
+			tag.Track = decodeText(f.data)
+		case "TYER", "TDRC":
+			tag.Year = decodeText(f.data)
+		case "APIC":
+			tag.Picture = decodePicture(f.data)
+		}
+	}
+	return tag, err
+}
+
+// readTag reads the ID3v2 tag at the start of file, returning nil if there is none.
+func readTag(file io.Reader, fileSize int64) (*rawTag, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(file, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if string(header[:3]) != "ID3" {
+		return nil, nil
+	}
+	tag := &rawTag{
+		version: header[3],
+		flags:   header[5],
+		size:    int64(headerSize + synchsafe(header[6:10])),
+	}
+	if tag.flags&flagFooter != 0 {
+		tag.size += headerSize
+	}
+	if tag.size > fileSize {
+		return nil, nil
+	}
+	tag.body = make([]byte, synchsafe(header[6:10]))
+	if _, err := io.ReadFull(file, tag.body); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+// parseFrames splits the tag body into frames. Frames parsed before an error are
+// still returned. Tags it can't split safely (ID3v2.2, unsynchronised) have none.
+func parseFrames(tag *rawTag) ([]frame, error) {
+	if tag.version < 3 || tag.flags&flagUnsynchronisation != 0 {
+		return nil, nil
+	}
+	body := tag.body
+	if tag.flags&flagExtendedHeader != 0 && len(body) >= 4 {
+		size := int(binary.BigEndian.Uint32(body[:4])) + 4
+		if tag.version >= 4 {
+			size = synchsafe(body[:4])
+		}
+		if size > len(body) {
+			return nil, errors.New("invalid ID3v2 extended header size")
+		}
+		body = body[size:]
+	}
+
+	var frames []frame
+	for len(body) >= frameSize && body[0] != 0 {
+		size := int(binary.BigEndian.Uint32(body[4:8]))
+		if tag.version >= 4 {
+			size = synchsafe(body[4:8])
+		}
+		if size > len(body)-frameSize {
+			return frames, errors.New("invalid ID3v2 frame size")
+		}
+		frames = append(frames, frame{
+			id:    string(body[:4]),
+			flags: [2]byte{body[8], body[9]},
+			data:  body[frameSize : frameSize+size],
+		})
+		body = body[frameSize+size:]
+	}
+	return frames, nil
+}
+
+func encode(tag Tag, version byte, extra []frame) []byte {
+	yearFrame := "TYER"
+	if version >= 4 {
+		yearFrame = "TDRC"
+	}
+
+	var frames bytes.Buffer
+	writeTextFrame(&frames, version, "TIT2", tag.Title)
+	writeTextFrame(&frames, version, "TPE1", tag.Artist)
+	writeTextFrame(&frames, version, "TALB", tag.Album)
+	writeTextFrame(&frames, version, "TRCK", tag.Track)
+	writeTextFrame(&frames, version, yearFrame, tag.Year)
+	if len(tag.Picture) > 0 {
+		var data bytes.Buffer
+		data.WriteByte(encodingISO88591)
+		data.WriteString(http.DetectContentType(tag.Picture))
+		data.WriteByte(0)
+		data.WriteByte(pictureTypeFrontCover)
+		data.WriteByte(0) // empty description
+		data.Write(tag.Picture)
+		writeFrame(&frames, version, frame{id: "APIC", data: data.Bytes()})
+	}
+	for _, f := range extra {
+		writeFrame(&frames, version, f)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("ID3")
+	out.Write([]byte{version, 0, 0})
+	out.Write(toSynchsafe(frames.Len()))
+	out.Write(frames.Bytes())
+	return out.Bytes()
+}
+
+func writeTextFrame(buf *bytes.Buffer, version byte, id string, value string) {
+	if value == "" {
+		return
+	}
+	writeFrame(buf, version, frame{id: id, data: encodeText(value)})
+}
+
+func writeFrame(buf *bytes.Buffer, version byte, f frame) {
+	buf.WriteString(f.id)
+	if version >= 4 {
+		buf.Write(toSynchsafe(len(f.data)))
+	} else {
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(f.data)))
+		buf.Write(size)
+	}
+	buf.Write(f.flags[:])
+	buf.Write(f.data)
+}
+
+// encodeText uses ISO-8859-1 when possible and UTF-16 with a BOM otherwise, the
+// only encodings ID3v2.3 supports.
+func encodeText(value string) []byte {
+	latin1 := true
+	for _, r := range value {
+		if r > 0xFF {
+			latin1 = false
+			break
+		}
+	}
+	if latin1 {
+		data := []byte{encodingISO88591}
+		for _, r := range value {
+			data = append(data, byte(r))
+		}
+		return data
+	}
+
+	data := []byte{encodingUTF16, 0xFF, 0xFE}
+	for _, u := range utf16.Encode([]rune(value)) {
+		data = append(data, byte(u), byte(u>>8))
+	}
+	return data
+}
+
+func decodeText(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	encoding, text := data[0], data[1:]
+	switch encoding {
+	case encodingUTF16, encodingUTF16BE:
+		bigEndian := encoding == encodingUTF16BE
+		if len(text) >= 2 && text[0] == 0xFE && text[1] == 0xFF {
+			bigEndian, text = true, text[2:]
+		} else if len(text) >= 2 && text[0] == 0xFF && text[1] == 0xFE {
+			bigEndian, text = false, text[2:]
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			if bigEndian {
+				units = append(units, uint16(text[i])<<8|uint16(text[i+1]))
+			} else {
+				units = append(units, uint16(text[i+1])<<8|uint16(text[i]))
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case encodingUTF8:
+		return strings.TrimRight(string(text), "\x00")
+	default:
+		runes := make([]rune, 0, len(text))
+		for _, b := range text {
+			runes = append(runes, rune(b))
+		}
+		return strings.TrimRight(string(runes), "\x00")
+	}
+}
+
+func decodePicture(data []byte) []byte {
+	if len(data) < 2 {
+		return nil
+	}
+	encoding := data[0]
+	rest := data[1:]
+	// MIME type is always ISO-8859-1
+	end := bytes.IndexByte(rest, 0)
+	if end < 0 || end+2 > len(rest) {
+		return nil
+	}
+	rest = rest[end+2:] // skip terminator and picture type
+
+	// Skip the description, whose terminator depends on the encoding
+	if encoding == encodingUTF16 || encoding == encodingUTF16BE {
+		for i := 0; i+1 < len(rest); i += 2 {
+			if rest[i] == 0 && rest[i+1] == 0 {
+				return rest[i+2:]
+			}
+		}
+		return nil
+	}
+	end = bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return nil
+	}
+	return rest[end+1:]
+}
+
+func synchsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
+
+func toSynchsafe(n int) []byte {
+	return []byte{byte(n>>21) & 0x7F, byte(n>>14) & 0x7F, byte(n>>7) & 0x7F, byte(n) & 0x7F}
+}
@@ -0,0 +1,127 @@
+package id3
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixtureMP3 is a single silent MPEG-1 Layer III frame
+func fixtureMP3() []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xFF, 0xFB, 0x90, 0x64})
+	return frame
+}
+
+// fixturePNG is the signature of a PNG file, enough for content type detection
+var fixturePNG = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestWriteFileAndReadBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "episode.mp3")
+	require.NoError(t, ioutil.WriteFile(path, fixtureMP3(), 0644))
+
+	tag := Tag{
+		Title:   "Episode 12: Café",
+		Artist:  "Test Author",
+		Album:   "Test Podcast",
+		Track:   "12",
+		Year:    "2024",
+		Picture: fixturePNG,
+	}
+	require.NoError(t, WriteFile(path, tag))
+
+	read, err := ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, tag, read)
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(content, fixtureMP3()), "audio data must be preserved")
+}
+
+func TestWriteFileReplacesExistingTag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "episode.mp3")
+	require.NoError(t, ioutil.WriteFile(path, fixtureMP3(), 0644))
+
+	require.NoError(t, WriteFile(path, Tag{Title: "Old Title", Album: "Old Album"}))
+	require.NoError(t, WriteFile(path, Tag{Title: "新しい", Artist: "Someone"}))
+
+	read, err := ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, Tag{Title: "新しい", Artist: "Someone"}, read)
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(content, []byte("ID3")))
+	assert.True(t, bytes.HasSuffix(content, fixtureMP3()))
+}
+
+func TestReadFileWithoutTag(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "episode.mp3")
+	require.NoError(t, ioutil.WriteFile(path, fixtureMP3(), 0644))
+
+	_, err := ReadFile(path)
+	assert.Error(t, err)
+}
+
+func TestWriteFileKeepsOtherFrames(t *testing.T) {
+	// A chapter with an embedded title sub-frame, as written by podcast editors
+	var chapter bytes.Buffer
+	chapter.WriteString("ch1\x00")
+	chapter.Write([]byte{0, 0, 0, 0, 0, 0, 0x75, 0x30, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	writeTextFrame(&chapter, 3, "TIT2", "Introduction")
+	chap := frame{id: "CHAP", data: chapter.Bytes()}
+	comment := frame{id: "COMM", data: []byte("\x00engShow notes\x00Thanks for listening")}
+
+	for _, version := range []byte{3, 4} {
+		t.Run(fmt.Sprintf("ID3v2.%d", version), func(t *testing.T) {
+			var frames bytes.Buffer
+			writeTextFrame(&frames, version, "TALB", "Old Album")
+			writeFrame(&frames, version, chap)
+			writeFrame(&frames, version, comment)
+			var existing bytes.Buffer
+			existing.WriteString("ID3")
+			existing.Write([]byte{version, 0, 0})
+			existing.Write(toSynchsafe(frames.Len()))
+			existing.Write(frames.Bytes())
+			existing.Write(fixtureMP3())
+
+			path := filepath.Join(t.TempDir(), "episode.mp3")
+			require.NoError(t, ioutil.WriteFile(path, existing.Bytes(), 0644))
+			require.NoError(t, WriteFile(path, Tag{Title: "New Title", Year: "2024"}))
+
+			read, err := ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, Tag{Title: "New Title", Year: "2024"}, read)
+
+			file, err := os.Open(path)
+			require.NoError(t, err)
+			defer file.Close()
+			info, err := file.Stat()
+			require.NoError(t, err)
+			tag, err := readTag(file, info.Size())
+			require.NoError(t, err)
+			require.NotNil(t, tag)
+			assert.Equal(t, version, tag.version)
+			parsed, err := parseFrames(tag)
+			require.NoError(t, err)
+			var ids []string
+			for _, f := range parsed {
+				ids = append(ids, f.id)
+			}
+			assert.Contains(t, parsed, chap)
+			assert.Contains(t, parsed, comment)
+			assert.NotContains(t, ids, "TALB")
+
+			content, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			assert.True(t, bytes.HasSuffix(content, fixtureMP3()))
+		})
+	}
+}
@@ -18,6 +18,9 @@ var (
 
 	defaultTags = []string{"h1", "h2", "h3", "h4", "h5", "h6", "div", "span", "hr", "p", "br", "b", "i", "strong", "em", "ol", "ul", "li", "a", "img", "pre", "code", "blockquote", "article", "section"}
 
+	// blockTags separate their text from the surrounding text when tags are stripped
+	blockTags = []string{"div", "p", "br", "hr", "h1", "h2", "h3", "h4", "h5", "h6", "ul", "ol", "li", "dl", "dt", "dd", "table", "tr", "td", "th", "blockquote", "pre", "article", "section", "header", "footer", "aside", "nav", "figure", "figcaption", "address"}
+
 	defaultAttributes = []string{"id", "class", "src", "href", "title", "alt", "name", "rel"}
 )
 
@@ -106,9 +109,12 @@ func HTML(s string) (output string) {
 		output = s
 	} else {
 
-		// First remove line breaks etc as these have no meaning outside html tags (except pre)
+		// First turn line breaks etc into spaces as these have no meaning outside html tags (except pre)
 		// this means pre sections will lose formatting... but will result in less unintentional paras.
-		s = strings.Replace(s, "\n", "", -1)
+		s = strings.Replace(s, "\r\n", " ", -1)
+		s = strings.Replace(s, "\n", " ", -1)
+		s = strings.Replace(s, "\r", " ", -1)
+		s = strings.Replace(s, "\t", " ", -1)
 
 		// Then replace line breaks with newlines, to preserve that formatting
 		s = strings.Replace(s, "</p>", "\n", -1)
@@ -117,19 +123,47 @@ func HTML(s string) (output string) {
 		s = strings.Replace(s, "<br/>", "\n", -1)
 		s = strings.Replace(s, "<br />", "\n", -1)
 
-		// Walk through the string removing all tags
+		// Walk through the string removing all tags. Runs of spaces collapse to a single
+		// space which is only written once more text follows, and block tags separate
+		// their contents with a space so words either side are not joined together.
 		b := bytes.NewBufferString("")
 		inTag := false
+		tagName := ""
+		readingName := false
+		pendingSpace := false
+		lastRune := '\n'
 		for _, r := range s {
-			switch r {
-			case '<':
+			switch {
+			case r == '<':
 				inTag = true
-			case '>':
+				readingName = true
+				tagName = ""
+			case r == '>' && inTag:
 				inTag = false
+				if includes(blockTags, strings.ToLower(strings.TrimPrefix(tagName, "/"))) {
+					pendingSpace = true
+				}
+			case inTag:
+				if readingName {
+					if r == ' ' || (r == '/' && tagName != "") {
+						readingName = false
+					} else {
+						tagName += string(r)
+					}
+				}
+			case r == '\n':
+				b.WriteRune(r)
+				lastRune = r
+				pendingSpace = false
+			case r == ' ':
+				pendingSpace = true
 			default:
-				if !inTag {
-					b.WriteRune(r)
+				if pendingSpace && lastRune != '\n' {
+					b.WriteRune(' ')
 				}
+				b.WriteRune(r)
+				lastRune = r
+				pendingSpace = false
 			}
 		}
 		output = b.String()
@@ -72,6 +72,31 @@ func TestHTML(t *testing.T) {
 			input:    "&lt;script&gt;alert('xss')&lt;/script&gt;",
 			expected: "&lt;script&gt;alert('xss')&lt;/script&gt;", // entities are escaped for safety
 		},
+		{
+			name:     "inline tags without surrounding spaces",
+			input:    "a<strong>b</strong>c",
+			expected: "abc",
+		},
+		{
+			name:     "inline tags with surrounding spaces",
+			input:    "a <strong>b</strong> c",
+			expected: "a b c",
+		},
+		{
+			name:     "line break before inline tag",
+			input:    "hello\n<strong>world</strong>",
+			expected: "hello world",
+		},
+		{
+			name:     "block tags do not join words",
+			input:    "<ul><li>one</li><li>two</li></ul><div>three</div>",
+			expected: "one two three",
+		},
+		{
+			name:     "repeated whitespace collapses",
+			input:    "Hello  <b>big</b> \t world ",
+			expected: "Hello big world",
+		},
 	}
 
 	for _, tt := range tests {
@@ -11,6 +11,7 @@ import (
 
 	"github.com/allenhutchison/podgrab/controllers"
 	"github.com/allenhutchison/podgrab/db"
+	"github.com/allenhutchison/podgrab/model"
 	"github.com/allenhutchison/podgrab/service"
 	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
@@ -25,6 +26,7 @@ func main() {
 		fmt.Println("statuse: ", err)
 	} else {
 		db.Migrate()
+		model.SetDefaultPageSize(db.GetOrCreateSetting().DefaultPageSize)
 	}
 	r := gin.Default()
 
@@ -52,11 +54,7 @@ func main() {
 			return raw == (time.Time{})
 		},
 		"formatDate": func(raw time.Time) string {
-			if raw == (time.Time{}) {
-				return ""
-			}
-
-			return raw.Format("Jan 2 2006")
+			return service.FormatDate(raw, "", nil)
 		},
 		"naturalDate": func(raw time.Time) string {
 			return service.NaturalTime(time.Now(), raw)
@@ -132,6 +130,9 @@ func main() {
 	//r.LoadHTMLGlob("client/*")
 	r.SetHTMLTemplate(tmpl)
 
+	// Registered outside the password protected group so container probes can reach it
+	r.GET("/healthz", controllers.HealthCheck)
+
 	pass := os.Getenv("PASSWORD")
 	var router *gin.RouterGroup
 	if pass != "" {
@@ -153,6 +154,7 @@ func main() {
 	router.GET("/podcasts/:id", controllers.GetPodcastById)
 	router.GET("/podcasts/:id/image", controllers.GetPodcastImageById)
 	router.DELETE("/podcasts/:id", controllers.DeletePodcastById)
+	router.PATCH("/podcasts/:id", controllers.PatchPodcastById)
 	router.GET("/podcasts/:id/items", controllers.GetPodcastItemsByPodcastId)
 	router.GET("/podcasts/:id/download", controllers.DownloadAllEpisodesByPodcastId)
 	router.DELETE("/podcasts/:id/items", controllers.DeletePodcastEpisodesById)
@@ -170,6 +172,7 @@ func main() {
 	router.GET("/podcastitems/:id/bookmark", controllers.BookmarkPodcastItem)
 	router.GET("/podcastitems/:id/unbookmark", controllers.UnbookmarkPodcastItem)
 	router.PATCH("/podcastitems/:id", controllers.PatchPodcastItemById)
+	router.POST("/podcastitems/seen", controllers.MarkPodcastItemsSeen)
 	router.GET("/podcastitems/:id/download", controllers.DownloadPodcastItem)
 	router.GET("/podcastitems/:id/delete", controllers.DeletePodcastItem)
 
@@ -177,6 +180,7 @@ func main() {
 	router.GET("/tags/:id", controllers.GetTagById)
 	router.GET("/tags/:id/rss", controllers.GetRssForTagById)
 	router.DELETE("/tags/:id", controllers.DeleteTagById)
+	router.PATCH("/tags/:id", controllers.PatchTagById)
 	router.POST("/tags", controllers.AddTag)
 	router.POST("/podcasts/:id/tags/:tagId", controllers.AddTagToPodcast)
 	router.DELETE("/podcasts/:id/tags/:tagId", controllers.RemoveTagFromPodcast)
@@ -224,12 +228,14 @@ func intiCron() {
 		log.Print(err)
 	}
 	service.UnlockMissedJobs()
+	go service.CachePodcastImages()
 	//gocron.Every(uint64(checkFrequency)).Minutes().Do(service.DownloadMissingEpisodes)
 	gocron.Every(uint64(checkFrequency)).Minutes().Do(service.RefreshEpisodes)
 	gocron.Every(uint64(checkFrequency)).Minutes().Do(service.CheckMissingFiles)
 	gocron.Every(uint64(checkFrequency) * 2).Minutes().Do(service.UnlockMissedJobs)
-	gocron.Every(uint64(checkFrequency) * 3).Minutes().Do(service.UpdateAllFileSizes)
+	gocron.Every(uint64(checkFrequency) * 3).Minutes().Do(service.VerifyFileSizes)
 	gocron.Every(uint64(checkFrequency)).Minutes().Do(service.DownloadMissingImages)
+	gocron.Every(uint64(checkFrequency)).Minutes().Do(service.CachePodcastImages)
 	gocron.Every(2).Days().Do(service.CreateBackup)
 	<-gocron.Start()
 }
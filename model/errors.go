@@ -17,3 +17,11 @@ type TagAlreadyExistsError struct {
 func (e *TagAlreadyExistsError) Error() string {
 	return fmt.Sprintf("Tag with this label already exists : " + e.Label)
 }
+
+type UnsupportedManifestVersionError struct {
+	Version int
+}
+
+func (e *UnsupportedManifestVersionError) Error() string {
+	return fmt.Sprintf("Unsupported library manifest version : %d", e.Version)
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+// LibraryManifestVersion is bumped whenever the manifest layout changes incompatibly.
+const LibraryManifestVersion = 1
+
+type LibraryManifest struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exportedAt"`
+	Podcasts   []ManifestPodcast `json:"podcasts"`
+}
+
+type ManifestPodcast struct {
+	ID      string                `json:"id"`
+	Title   string                `json:"title"`
+	URL     string                `json:"url"`
+	Author  string                `json:"author"`
+	Summary string                `json:"summary"`
+	Image   string                `json:"image"`
+	Items   []ManifestPodcastItem `json:"items"`
+}
+
+type ManifestPodcastItem struct {
+	ID             string    `json:"id"`
+	GUID           string    `json:"guid"`
+	Title          string    `json:"title"`
+	PubDate        time.Time `json:"pubDate"`
+	FileURL        string    `json:"fileUrl"`
+	Duration       int       `json:"duration"`
+	DownloadStatus int       `json:"downloadStatus"`
+	FilePath       string    `json:"filePath"`
+	IsPlayed       bool      `json:"isPlayed"`
+}
@@ -51,6 +51,17 @@ type PodcastData struct {
 			Title string `xml:"title"`
 			Link  string `xml:"link"`
 		} `xml:"image"`
+		Person []struct {
+			Text  string `xml:",chardata"`
+			Role  string `xml:"role,attr"`
+			Group string `xml:"group,attr"`
+			Href  string `xml:"href,attr"`
+			Img   string `xml:"img,attr"`
+		} `xml:"person"`
+		Funding []struct {
+			Text string `xml:",chardata"`
+			URL  string `xml:"url,attr"`
+		} `xml:"funding"`
 		Item []struct {
 			Text        string `xml:",chardata"`
 			Title       string `xml:"title"`
@@ -24,15 +24,42 @@ type EpisodesFilter struct {
 	Pagination
 	IsDownloaded *string     `uri:"isDownloaded" query:"isDownloaded" json:"isDownloaded" form:"isDownloaded"`
 	IsPlayed     *string     `uri:"isPlayed" query:"isPlayed" json:"isPlayed" form:"isPlayed"`
+	IsSeen       *string     `uri:"isSeen" query:"isSeen" json:"isSeen" form:"isSeen"`
 	Sorting      EpisodeSort `uri:"sorting" query:"sorting" json:"sorting" form:"sorting"`
 	Q            string      `uri:"q" query:"q" json:"q" form:"q"`
 	TagIds       []string    `uri:"tagIds" query:"tagIds[]" json:"tagIds" form:"tagIds[]"`
 	PodcastIds   []string    `uri:"podcastIds" query:"podcastIds[]" json:"podcastIds" form:"podcastIds[]"`
+	MinDuration  *int        `uri:"minDuration" query:"minDuration" json:"minDuration" form:"minDuration"`
+	MaxDuration  *int        `uri:"maxDuration" query:"maxDuration" json:"maxDuration" form:"maxDuration"`
+}
+
+// MaxPageSize caps the number of episodes a single page may request.
+const MaxPageSize = 100
+
+const defaultPageSize = 20
+
+// DefaultPageSize is the Count used when a request does not ask for one.
+// It is loaded from the settings at startup through SetDefaultPageSize.
+var DefaultPageSize = defaultPageSize
+
+// SetDefaultPageSize changes DefaultPageSize, clamped to MaxPageSize. Zero or a
+// negative size restores the built in default.
+func SetDefaultPageSize(size int) {
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+	DefaultPageSize = size
 }
 
 func (filter *EpisodesFilter) VerifyPaginationValues() {
-	if filter.Count == 0 {
-		filter.Count = 20
+	if filter.Count <= 0 {
+		filter.Count = DefaultPageSize
+	}
+	if filter.Count > MaxPageSize {
+		filter.Count = MaxPageSize
 	}
 	if filter.Page == 0 {
 		filter.Page = 1
@@ -42,8 +69,27 @@ func (filter *EpisodesFilter) VerifyPaginationValues() {
 	}
 }
 
+func getTotalPages(totalCount int64, count int) int {
+	return int(math.Ceil(float64(totalCount) / float64(count)))
+}
+
+// ClampPage moves a page requested beyond the end of the results back to the last page.
+func (filter *EpisodesFilter) ClampPage(totalCount int64) {
+	if filter.Count <= 0 {
+		return
+	}
+	totalPages := getTotalPages(totalCount, filter.Count)
+	if filter.Page > totalPages {
+		filter.Page = totalPages
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+}
+
 func (filter *EpisodesFilter) SetCounts(totalCount int64) {
-	totalPages := int(math.Ceil(float64(totalCount) / float64(filter.Count)))
+	filter.ClampPage(totalCount)
+	totalPages := getTotalPages(totalCount, filter.Count)
 	nextPage, previousPage := 0, 0
 	if filter.Page < totalPages {
 		nextPage = filter.Page + 1
@@ -238,6 +238,66 @@ func TestEpisodesFilter_SetCounts(t *testing.T) {
 	}
 }
 
+func TestEpisodesFilter_SetCountsClampsPage(t *testing.T) {
+	tests := []struct {
+		name             string
+		page             int
+		totalCount       int64
+		expectedPage     int
+		expectedNextPage int
+		expectedPrevPage int
+	}{
+		{
+			name:             "page beyond total is moved to last page",
+			page:             99,
+			totalCount:       100,
+			expectedPage:     5,
+			expectedNextPage: 0,
+			expectedPrevPage: 4,
+		},
+		{
+			name:             "page one past the end",
+			page:             4,
+			totalCount:       45,
+			expectedPage:     3,
+			expectedNextPage: 0,
+			expectedPrevPage: 2,
+		},
+		{
+			name:             "page within range is untouched",
+			page:             2,
+			totalCount:       100,
+			expectedPage:     2,
+			expectedNextPage: 3,
+			expectedPrevPage: 1,
+		},
+		{
+			name:             "no results falls back to first page",
+			page:             7,
+			totalCount:       0,
+			expectedPage:     1,
+			expectedNextPage: 0,
+			expectedPrevPage: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := EpisodesFilter{
+				Pagination: Pagination{
+					Page:  tt.page,
+					Count: 20,
+				},
+			}
+			filter.SetCounts(tt.totalCount)
+
+			assert.Equal(t, tt.expectedPage, filter.Page, "Page mismatch")
+			assert.Equal(t, tt.expectedNextPage, filter.NextPage, "NextPage mismatch")
+			assert.Equal(t, tt.expectedPrevPage, filter.PreviousPage, "PreviousPage mismatch")
+		})
+	}
+}
+
 func TestEpisodeSortConstants(t *testing.T) {
 	// Verify the constants are defined correctly
 	assert.Equal(t, EpisodeSort("release_asc"), RELEASE_ASC)
@@ -312,3 +372,30 @@ func TestEpisodesFilter_IntegrationVerifyAndSetCounts(t *testing.T) {
 	assert.Equal(t, 5, filter.TotalPages)
 	assert.Equal(t, 100, filter.TotalCount)
 }
+
+func TestEpisodesFilter_VerifyPaginationValuesUsesDefaultPageSize(t *testing.T) {
+	defer SetDefaultPageSize(0)
+
+	tests := []struct {
+		name          string
+		defaultSize   int
+		count         int
+		expectedCount int
+	}{
+		{name: "unconfigured keeps 20", defaultSize: 0, count: 0, expectedCount: 20},
+		{name: "configured default applied to zero count", defaultSize: 50, count: 0, expectedCount: 50},
+		{name: "configured default applied to negative count", defaultSize: 50, count: -5, expectedCount: 50},
+		{name: "explicit count wins over default", defaultSize: 50, count: 10, expectedCount: 10},
+		{name: "configured default is clamped", defaultSize: 1000, count: 0, expectedCount: MaxPageSize},
+		{name: "requested count is clamped", defaultSize: 0, count: 5000, expectedCount: MaxPageSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetDefaultPageSize(tt.defaultSize)
+			filter := EpisodesFilter{Pagination: Pagination{Count: tt.count}}
+			filter.VerifyPaginationValues()
+			assert.Equal(t, tt.expectedCount, filter.Count)
+		})
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/allenhutchison/podgrab/db"
@@ -22,10 +24,19 @@ import (
 	stringy "github.com/gobeam/stringy"
 )
 
+var activeDownloads int64
+
+// ActiveDownloads returns the number of episode downloads currently in progress.
+func ActiveDownloads() int64 {
+	return atomic.LoadInt64(&activeDownloads)
+}
+
 func Download(link string, episodeTitle string, podcastName string, prefix string) (string, error) {
 	if link == "" {
 		return "", errors.New("Download path empty")
 	}
+	atomic.AddInt64(&activeDownloads, 1)
+	defer atomic.AddInt64(&activeDownloads, -1)
 	client := httpClient()
 
 	req, err := getRequest(link)
@@ -38,6 +49,7 @@ func Download(link string, episodeTitle string, podcastName string, prefix strin
 		Logger.Errorw("Error getting response: "+link, err)
 		return "", err
 	}
+	defer resp.Body.Close()
 
 	fileName := getFileName(link, episodeTitle, ".mp3")
 	if prefix != "" {
@@ -51,26 +63,61 @@ func Download(link string, episodeTitle string, podcastName string, prefix strin
 		return finalPath, nil
 	}
 
-	file, err := os.Create(finalPath)
-	if err != nil {
-		Logger.Errorw("Error creating file"+link, err)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("unexpected status downloading %s: %s", link, resp.Status)
+		Logger.Errorw("Error getting response: "+link, err)
 		return "", err
 	}
-	defer resp.Body.Close()
-	_, erra := io.Copy(file, resp.Body)
-	//fmt.Println(size)
-	defer file.Close()
-	if erra != nil {
+
+	// Download to a .part file and only move it into place once it is complete, so
+	// an interrupted download never looks like a finished episode.
+	partPath := finalPath + ".part"
+	if err := downloadToFile(resp, partPath); err != nil {
 		Logger.Errorw("Error saving file"+link, err)
-		return "", erra
+		os.Remove(partPath)
+		return "", err
+	}
+	if err := os.Rename(partPath, finalPath); err != nil {
+		Logger.Errorw("Error saving file"+link, err)
+		os.Remove(partPath)
+		return "", err
 	}
 	changeOwnership(finalPath)
 	return finalPath, nil
 
 }
 
-func GetPodcastLocalImagePath(link string, podcastName string) string {
+func downloadToFile(resp *http.Response, filePath string) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(file, resp.Body)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if resp.ContentLength > 0 && written != resp.ContentLength {
+		file.Close()
+		return fmt.Errorf("incomplete download: got %d of %d bytes", written, resp.ContentLength)
+	}
+	return file.Close()
+}
+
+// GetPodcastFolderName returns the folder, relative to the data directory, that
+// holds the podcast's files: its FolderOverride if set, otherwise its title.
+func GetPodcastFolderName(podcast *db.Podcast) string {
+	if override := strings.Trim(sanitize.Path(podcast.FolderOverride), "/"); override != "" && override != "." {
+		return override
+	}
+	return podcast.Title
+}
+
+func GetPodcastLocalImagePath(link string, podcastName string, prefix string) string {
 	fileName := getFileName(link, "folder", ".jpg")
+	if prefix != "" {
+		fileName = fmt.Sprintf("%s-%s", prefix, fileName)
+	}
 	folder := createDataFolderIfNotExists(podcastName)
 
 	finalPath := path.Join(folder, fileName)
@@ -79,7 +126,10 @@ func GetPodcastLocalImagePath(link string, podcastName string) string {
 
 func CreateNfoFile(podcast *db.Podcast) error {
 	fileName := "album.nfo"
-	folder := createDataFolderIfNotExists(podcast.Title)
+	if prefix := getPodcastFilePrefix(podcast); prefix != "" {
+		fileName = fmt.Sprintf("%s-%s", prefix, fileName)
+	}
+	folder := createDataFolderIfNotExists(GetPodcastFolderName(podcast))
 
 	finalPath := path.Join(folder, fileName)
 
@@ -103,7 +153,9 @@ func CreateNfoFile(podcast *db.Podcast) error {
 	return ioutil.WriteFile(finalPath, []byte(toPersist), 0644)
 }
 
-func DownloadPodcastCoverImage(link string, podcastName string) (string, error) {
+// DownloadPodcastCoverImage downloads the podcast artwork into the podcast folder, replacing
+// any previously cached copy. The existing file is left alone if the download fails.
+func DownloadPodcastCoverImage(link string, podcastName string, prefix string) (string, error) {
 	if link == "" {
 		return "", errors.New("Download path empty")
 	}
@@ -119,29 +171,29 @@ func DownloadPodcastCoverImage(link string, podcastName string) (string, error)
 		Logger.Errorw("Error getting response: "+link, err)
 		return "", err
 	}
-
-	fileName := getFileName(link, "folder", ".jpg")
-	folder := createDataFolderIfNotExists(podcastName)
-
-	finalPath := path.Join(folder, fileName)
-	if _, err := os.Stat(finalPath); !os.IsNotExist(err) {
-		changeOwnership(finalPath)
-		return finalPath, nil
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Did not receive 200 for %s : %s", link, resp.Status)
 	}
 
-	file, err := os.Create(finalPath)
+	finalPath := GetPodcastLocalImagePath(link, podcastName, prefix)
+	tempPath := finalPath + ".tmp"
+	file, err := os.Create(tempPath)
 	if err != nil {
 		Logger.Errorw("Error creating file"+link, err)
 		return "", err
 	}
-	defer resp.Body.Close()
 	_, erra := io.Copy(file, resp.Body)
-	//fmt.Println(size)
-	defer file.Close()
+	file.Close()
 	if erra != nil {
-		Logger.Errorw("Error saving file"+link, err)
+		Logger.Errorw("Error saving file"+link, erra)
+		os.Remove(tempPath)
 		return "", erra
 	}
+	if err := os.Rename(tempPath, finalPath); err != nil {
+		os.Remove(tempPath)
+		return "", err
+	}
 	changeOwnership(finalPath)
 	return finalPath, nil
 }
@@ -228,6 +280,121 @@ func GetAllBackupFiles() ([]string, error) {
 	return files, err
 }
 
+var mediaExtensions = []string{".mp3", ".m4a", ".m4b", ".aac", ".ogg", ".oga", ".opus", ".wav", ".flac", ".mp4", ".m4v", ".mov", ".webm"}
+
+func isMediaFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, mediaExt := range mediaExtensions {
+		if ext == mediaExt {
+			return true
+		}
+	}
+	return false
+}
+
+// getReferencedFiles returns the absolute download paths of all podcast items.
+func getReferencedFiles() (map[string]bool, error) {
+	paths, err := db.GetAllDownloadPaths()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, path := range paths {
+		if abs, err := filepath.Abs(path); err == nil {
+			referenced[abs] = true
+		}
+	}
+	return referenced, nil
+}
+
+// isInsideRoot reports whether the absolute path is rootDir or below it.
+func isInsideRoot(rootDir string, path string) bool {
+	rel, err := filepath.Rel(rootDir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// FindOrphanedFiles walks rootDir and returns the media files that no podcast item
+// points to. Symlinks are never followed or reported.
+func FindOrphanedFiles(rootDir string) ([]string, error) {
+	root, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	referenced, err := getReferencedFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Walk uses Lstat, so symlinks show up here rather than being followed
+		if !info.Mode().IsRegular() || !isMediaFile(path) {
+			return nil
+		}
+		if !referenced[path] {
+			orphans = append(orphans, path)
+		}
+		return nil
+	})
+	return orphans, err
+}
+
+// DeleteOrphanedFiles removes files previously returned by FindOrphanedFiles. Each
+// path is checked again so nothing outside rootDir, no symlink and no file an item
+// has started referencing in the meantime is removed. It returns the number of
+// files deleted.
+func DeleteOrphanedFiles(rootDir string, files []string) (int, error) {
+	root, err := filepath.Abs(rootDir)
+	if err != nil {
+		return 0, err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return 0, err
+	}
+	referenced, err := getReferencedFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	var lastErr error
+	for _, file := range files {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		info, err := os.Lstat(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		// A symlinked folder along the way could still lead outside the root
+		realPath, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !isInsideRoot(root, path) || !isInsideRoot(realRoot, realPath) {
+			lastErr = fmt.Errorf("%s is outside %s", file, rootDir)
+			continue
+		}
+		if !info.Mode().IsRegular() || !isMediaFile(path) || referenced[path] {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			lastErr = err
+			continue
+		}
+		deleted++
+	}
+	return deleted, lastErr
+}
+
 func GetFileSize(path string) (int64, error) {
 	info, err := os.Stat(path)
 	if err != nil {
@@ -398,6 +565,11 @@ func getFileName(link string, title string, defaultExtension string) string {
 
 }
 
+// getPodcastSlug returns the podcast title in the form used for file names.
+func getPodcastSlug(podcast *db.Podcast) string {
+	return stringy.New(cleanFileName(podcast.Title)).KebabCase().Get()
+}
+
 func cleanFileName(original string) string {
 	return sanitize.Name(original)
 }
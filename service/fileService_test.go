@@ -0,0 +1,161 @@
+package service
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/allenhutchison/podgrab/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindAndDeleteOrphanedFiles(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	root := t.TempDir()
+	outside := t.TempDir()
+	writeFile := func(path string) string {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte("data"), 0644))
+		return path
+	}
+
+	referenced := writeFile(filepath.Join(root, "Show", "referenced.mp3"))
+	orphan := writeFile(filepath.Join(root, "Show", "orphan.mp3"))
+	nestedOrphan := writeFile(filepath.Join(root, "Other Show", "season 1", "orphan.M4A"))
+	writeFile(filepath.Join(root, "Show", "folder.jpg"))
+	writeFile(filepath.Join(root, "Show", "album.nfo"))
+	outsideFile := writeFile(filepath.Join(outside, "outside.mp3"))
+	require.NoError(t, os.Symlink(outsideFile, filepath.Join(root, "Show", "link.mp3")))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "Linked")))
+
+	podcast, err := db.CreateTestPodcast(testDB, "Show")
+	require.NoError(t, err)
+	item, err := db.CreateTestPodcastItem(testDB, podcast, "Referenced", db.Downloaded)
+	require.NoError(t, err)
+	item.DownloadPath = referenced
+	require.NoError(t, testDB.Save(item).Error)
+
+	orphans, err := FindOrphanedFiles(root)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{orphan, nestedOrphan}, orphans)
+
+	t.Run("only confirmed orphans inside the root are deleted", func(t *testing.T) {
+		deleted, err := DeleteOrphanedFiles(root, []string{
+			orphan,
+			referenced,
+			outsideFile,
+			filepath.Join(root, "Show", "link.mp3"),
+			filepath.Join(root, "Linked", "outside.mp3"),
+			filepath.Join(root, "Show", "..", "..", filepath.Base(outside), "outside.mp3"),
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, deleted)
+
+		assert.NoFileExists(t, orphan)
+		assert.FileExists(t, referenced)
+		assert.FileExists(t, outsideFile)
+		assert.FileExists(t, nestedOrphan)
+	})
+
+	t.Run("nothing left to find after deleting", func(t *testing.T) {
+		deleted, err := DeleteOrphanedFiles(root, []string{nestedOrphan})
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		orphans, err := FindOrphanedFiles(root)
+		require.NoError(t, err)
+		assert.Empty(t, orphans)
+	})
+}
+
+func TestDownloadFailureLeavesNoPartialFile(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	dataDir := t.TempDir()
+	t.Setenv("DATA", dataDir)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/truncated.mp3":
+			w.Header().Set("Content-Length", "1000")
+			w.Write(make([]byte, 100))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		case "/complete.mp3":
+			w.Write(make([]byte, 1000))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	podcast, err := db.CreateTestPodcast(testDB, "Flaky Podcast")
+	require.NoError(t, err)
+	newItem := func(title string, fileName string) *db.PodcastItem {
+		item, err := db.CreateTestPodcastItem(testDB, podcast, title, db.NotDownloaded)
+		require.NoError(t, err)
+		item.FileURL = server.URL + "/" + fileName
+		require.NoError(t, testDB.Save(item).Error)
+		return item
+	}
+	folder := filepath.Join(dataDir, "Flaky Podcast")
+
+	tests := []struct {
+		name     string
+		title    string
+		fileName string
+	}{
+		{"connection dropped mid-stream", "Truncated", "truncated.mp3"},
+		{"error status", "Missing", "missing.mp3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := newItem(tt.title, tt.fileName)
+
+			assert.Error(t, DownloadSingleEpisode(item.ID))
+
+			finalPath := filepath.Join(folder, strings.ToLower(tt.title)+".mp3")
+			assert.NoFileExists(t, finalPath)
+			assert.NoFileExists(t, finalPath+".part")
+
+			var saved db.PodcastItem
+			require.NoError(t, db.GetPodcastItemById(item.ID, &saved))
+			assert.Equal(t, db.NotDownloaded, saved.DownloadStatus)
+			assert.Empty(t, saved.DownloadPath)
+		})
+	}
+
+	t.Run("scheduled downloads keep failed episodes queued", func(t *testing.T) {
+		require.NoError(t, testDB.Exec("DELETE FROM podcast_items").Error)
+		failed := newItem("Truncated", "truncated.mp3")
+		complete := newItem("Complete", "complete.mp3")
+
+		require.NoError(t, DownloadMissingEpisodes())
+
+		var saved db.PodcastItem
+		require.NoError(t, db.GetPodcastItemById(failed.ID, &saved))
+		assert.Equal(t, db.NotDownloaded, saved.DownloadStatus)
+		assert.Empty(t, saved.DownloadPath)
+		assert.NoFileExists(t, filepath.Join(folder, "truncated.mp3"))
+
+		require.NoError(t, db.GetPodcastItemById(complete.ID, &saved))
+		assert.Equal(t, db.Downloaded, saved.DownloadStatus)
+		assert.Equal(t, filepath.Join(folder, "complete.mp3"), saved.DownloadPath)
+		assert.FileExists(t, saved.DownloadPath)
+		entries, err := ioutil.ReadDir(folder)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			assert.NotEqual(t, ".part", filepath.Ext(entry.Name()))
+		}
+	})
+}
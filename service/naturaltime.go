@@ -6,6 +6,25 @@ import (
 	"time"
 )
 
+// DefaultDateLayout is used by FormatDate when no layout is given.
+const DefaultDateLayout = "Jan 2 2006"
+
+// FormatDate renders value in loc using layout. An empty layout falls back to
+// DefaultDateLayout, a nil loc keeps value's own location and the zero time
+// renders as an empty string.
+func FormatDate(value time.Time, layout string, loc *time.Location) string {
+	if value.IsZero() {
+		return ""
+	}
+	if layout == "" {
+		layout = DefaultDateLayout
+	}
+	if loc != nil {
+		value = value.In(loc)
+	}
+	return value.Format(layout)
+}
+
 func NaturalTime(base, value time.Time) string {
 	if value.Before(base) {
 		return pastNaturalTime(base, value)
@@ -344,3 +344,63 @@ func TestNaturalTimeEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatDate(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone data not available")
+	}
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	tests := []struct {
+		name     string
+		value    time.Time
+		layout   string
+		loc      *time.Location
+		expected string
+	}{
+		{
+			name:     "default layout when empty",
+			value:    time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+			expected: "Jan 15 2024",
+		},
+		{
+			name:     "custom layout",
+			value:    time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+			layout:   "2006-01-02 15:04",
+			expected: "2024-01-15 10:30",
+		},
+		{
+			name:     "nil location keeps the original zone",
+			value:    time.Date(2024, 1, 15, 23, 30, 0, 0, tokyo),
+			layout:   "2006-01-02 15:04 MST",
+			expected: "2024-01-15 23:30 JST",
+		},
+		{
+			name:     "converts to location before formatting",
+			value:    time.Date(2024, 1, 15, 3, 30, 0, 0, time.UTC),
+			layout:   "2006-01-02 15:04 MST",
+			loc:      newYork,
+			expected: "2024-01-14 22:30 EST",
+		},
+		{
+			name:     "conversion can change the day with the default layout",
+			value:    time.Date(2024, 1, 15, 20, 0, 0, 0, time.UTC),
+			loc:      tokyo,
+			expected: "Jan 16 2024",
+		},
+		{
+			name:     "zero time renders empty",
+			value:    time.Time{},
+			layout:   "2006-01-02",
+			loc:      tokyo,
+			expected: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, FormatDate(tt.value, tt.layout, tt.loc))
+		})
+	}
+}
@@ -1,12 +1,20 @@
 package service
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,6 +22,7 @@ import (
 
 	"github.com/TheHippo/podcastindex"
 	"github.com/allenhutchison/podgrab/db"
+	"github.com/allenhutchison/podgrab/internal/id3"
 	"github.com/allenhutchison/podgrab/model"
 	"github.com/antchfx/xmlquery"
 	strip "github.com/grokify/html-strip-tags-go"
@@ -37,13 +46,20 @@ func ParseOpml(content string) (model.OpmlModel, error) {
 
 //FetchURL is
 func FetchURL(url string) (model.PodcastData, []byte, error) {
-	body, err := makeQuery(url)
+	data, body, _, err := fetchFeed(url)
+	return data, body, err
+}
+
+// fetchFeed is FetchURL that also reports where the feed permanently moved to, if
+// every redirect on the way was a 301 or 308.
+func fetchFeed(url string) (model.PodcastData, []byte, string, error) {
+	body, movedTo, err := makeQueryFollowingRedirects(url)
 	if err != nil {
-		return model.PodcastData{}, nil, err
+		return model.PodcastData{}, nil, "", err
 	}
 	var response model.PodcastData
 	err = xml.Unmarshal(body, &response)
-	return response, body, err
+	return response, body, movedTo, err
 }
 func GetPodcastById(id string) *db.Podcast {
 	var podcast db.Podcast
@@ -182,6 +198,100 @@ func ExportOmpl(usePodgrabLink bool, baseUrl string) ([]byte, error) {
 	}
 }
 
+// ExportLibraryManifest serializes every podcast with its episodes and their
+// download and played state into a versioned JSON manifest.
+func ExportLibraryManifest() ([]byte, error) {
+	var podcasts []db.Podcast
+	if err := db.GetAllPodcasts(&podcasts, ""); err != nil {
+		return nil, err
+	}
+
+	manifest := model.LibraryManifest{
+		Version:    model.LibraryManifestVersion,
+		ExportedAt: time.Now().UTC(),
+		Podcasts:   []model.ManifestPodcast{},
+	}
+	for _, podcast := range podcasts {
+		var podcastItems []db.PodcastItem
+		if err := db.GetAllPodcastItemsByPodcastIds([]string{podcast.ID}, &podcastItems); err != nil {
+			return nil, err
+		}
+
+		toAdd := model.ManifestPodcast{
+			ID:      podcast.ID,
+			Title:   podcast.Title,
+			URL:     podcast.URL,
+			Author:  podcast.Author,
+			Summary: podcast.Summary,
+			Image:   podcast.Image,
+			Items:   []model.ManifestPodcastItem{},
+		}
+		for _, item := range podcastItems {
+			toAdd.Items = append(toAdd.Items, model.ManifestPodcastItem{
+				ID:             item.ID,
+				GUID:           item.GUID,
+				Title:          item.Title,
+				PubDate:        item.PubDate.UTC(),
+				FileURL:        item.FileURL,
+				Duration:       item.Duration,
+				DownloadStatus: int(item.DownloadStatus),
+				FilePath:       item.DownloadPath,
+				IsPlayed:       item.IsPlayed,
+			})
+		}
+		manifest.Podcasts = append(manifest.Podcasts, toAdd)
+	}
+
+	return json.MarshalIndent(manifest, "", "  ")
+}
+
+// ImportLibraryManifest restores podcasts and episodes from a manifest written by
+// ExportLibraryManifest, keeping their ids. Podcasts are matched by URL and episodes
+// by GUID, or by enclosure URL for episodes without one, so rows that already exist
+// are left untouched and importing twice is harmless. Nothing is imported if any
+// part fails.
+func ImportLibraryManifest(data []byte) error {
+	var manifest model.LibraryManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return err
+	}
+	if manifest.Version != model.LibraryManifestVersion {
+		return &model.UnsupportedManifestVersionError{Version: manifest.Version}
+	}
+
+	var podcasts []db.Podcast
+	for _, manifestPodcast := range manifest.Podcasts {
+		podcast := db.Podcast{
+			Base:    db.Base{ID: manifestPodcast.ID},
+			Title:   manifestPodcast.Title,
+			URL:     manifestPodcast.URL,
+			Author:  manifestPodcast.Author,
+			Summary: manifestPodcast.Summary,
+			Image:   manifestPodcast.Image,
+		}
+		for _, manifestItem := range manifestPodcast.Items {
+			downloadStatus := db.DownloadStatus(manifestItem.DownloadStatus)
+			if downloadStatus == db.Downloading {
+				// Nothing is downloading any more, queue it again
+				downloadStatus = db.NotDownloaded
+			}
+			podcast.PodcastItems = append(podcast.PodcastItems, db.PodcastItem{
+				Base:           db.Base{ID: manifestItem.ID},
+				GUID:           manifestItem.GUID,
+				Title:          manifestItem.Title,
+				PubDate:        manifestItem.PubDate,
+				FileURL:        manifestItem.FileURL,
+				Duration:       manifestItem.Duration,
+				DownloadStatus: downloadStatus,
+				DownloadPath:   manifestItem.FilePath,
+				IsPlayed:       manifestItem.IsPlayed,
+			})
+		}
+		podcasts = append(podcasts, podcast)
+	}
+	return db.RestorePodcasts(podcasts)
+}
+
 func getItunesImageUrl(body []byte) string {
 	doc, err := xmlquery.Parse(strings.NewReader(string(body)))
 	if err != nil {
@@ -208,7 +318,7 @@ func getItunesImageUrl(body []byte) string {
 
 func AddPodcast(url string) (db.Podcast, error) {
 	var podcast db.Podcast
-	err := db.GetPodcastByURL(url, &podcast)
+	err := findPodcastByFeedURL(url, &podcast)
 	setting := db.GetOrCreateSetting()
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		data, body, err := FetchURL(url)
@@ -224,6 +334,8 @@ func AddPodcast(url string) (db.Podcast, error) {
 			Author:  data.Channel.Author,
 			Image:   data.Channel.Image.URL,
 			URL:     url,
+			People:  getPodcastPeople(data),
+			Funding: getPodcastFunding(data),
 		}
 
 		if podcast.Image == "" {
@@ -231,7 +343,7 @@ func AddPodcast(url string) (db.Podcast, error) {
 		}
 
 		err = db.CreatePodcast(&podcast)
-		go DownloadPodcastCoverImage(podcast.Image, podcast.Title)
+		go cachePodcastImage(&podcast)
 		if setting.GenerateNFOFile {
 			go CreateNfoFile(&podcast)
 		}
@@ -242,14 +354,106 @@ func AddPodcast(url string) (db.Podcast, error) {
 
 }
 
+func getPodcastPeople(data model.PodcastData) []db.PodcastPerson {
+	var people []db.PodcastPerson
+	for _, person := range data.Channel.Person {
+		name := strings.TrimSpace(person.Text)
+		if name == "" {
+			continue
+		}
+		people = append(people, db.PodcastPerson{
+			Name:  name,
+			Role:  person.Role,
+			Group: person.Group,
+			Href:  person.Href,
+			Img:   person.Img,
+		})
+	}
+	return people
+}
+
+func getPodcastFunding(data model.PodcastData) []db.PodcastFunding {
+	var funding []db.PodcastFunding
+	for _, fund := range data.Channel.Funding {
+		if fund.URL == "" {
+			continue
+		}
+		funding = append(funding, db.PodcastFunding{
+			URL:  fund.URL,
+			Text: strings.TrimSpace(fund.Text),
+		})
+	}
+	return funding
+}
+
+// updatePodcastPeopleAndFunding stores the feed's podcast:person and podcast:funding
+// entries, leaving the rows alone when nothing changed since the last refresh.
+func updatePodcastPeopleAndFunding(podcast *db.Podcast, data model.PodcastData) error {
+	people := getPodcastPeople(data)
+	funding := getPodcastFunding(data)
+
+	var existingPeople []db.PodcastPerson
+	var existingFunding []db.PodcastFunding
+	if err := db.GetPodcastPeopleAndFunding(podcast.ID, &existingPeople, &existingFunding); err != nil {
+		return err
+	}
+	if samePodcastPeople(existingPeople, people) && samePodcastFunding(existingFunding, funding) {
+		return nil
+	}
+	return db.ReplacePodcastPeopleAndFunding(podcast.ID, people, funding)
+}
+
+func samePodcastPeople(a, b []db.PodcastPerson) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Role != b[i].Role || a[i].Group != b[i].Group || a[i].Href != b[i].Href || a[i].Img != b[i].Img {
+			return false
+		}
+	}
+	return true
+}
+
+func samePodcastFunding(a, b []db.PodcastFunding) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].URL != b[i].URL || a[i].Text != b[i].Text {
+			return false
+		}
+	}
+	return true
+}
+
 func AddPodcastItems(podcast *db.Podcast, newPodcast bool) error {
 	//fmt.Println("Creating: " + podcast.ID)
-	data, _, err := FetchURL(podcast.URL)
+	data, body, movedTo, err := fetchFeed(podcast.URL)
 	if err != nil {
 		//log.Fatal(err)
 		return err
 	}
+	if movedTo != "" {
+		updateMovedPodcastURL(podcast, movedTo)
+	}
+	image := data.Channel.Image.URL
+	if image == "" {
+		image = getItunesImageUrl(body)
+	}
+	if image != "" && image != podcast.Image {
+		podcast.Image = image
+		if err := db.UpdatePodcastImage(podcast.ID, image); err != nil {
+			Logger.Errorw("Error updating podcast image: "+podcast.ID, err)
+		}
+	}
+	if err := updatePodcastPeopleAndFunding(podcast, data); err != nil {
+		Logger.Errorw("Error updating podcast people and funding: "+podcast.ID, err)
+	}
 	setting := db.GetOrCreateSetting()
+	if podcast.FollowArchiveLinks {
+		appendArchivePages(&data, podcast.URL, setting.MaxArchivePages)
+	}
 	limit := setting.InitialDownloadCount
 	// if len(data.Channel.Item) < limit {
 	// 	limit = len(data.Channel.Item)
@@ -274,28 +478,7 @@ func AddPodcastItems(podcast *db.Podcast, newPodcast bool) error {
 		_, keyExists := keyMap[obj.Guid.Text]
 		if !keyExists {
 			duration, _ := strconv.Atoi(obj.Duration)
-			toParse := strings.TrimSpace(obj.PubDate)
-
-			pubDate, _ := time.Parse(time.RFC1123Z, toParse)
-			if (pubDate == time.Time{}) {
-				pubDate, _ = time.Parse(time.RFC1123, toParse)
-			}
-			if (pubDate == time.Time{}) {
-				//	RFC1123     = "Mon, 02 Jan 2006 15:04:05 MST"
-				modifiedRFC1123 := "Mon, 2 Jan 2006 15:04:05 MST"
-				pubDate, _ = time.Parse(modifiedRFC1123, toParse)
-			}
-			if (pubDate == time.Time{}) {
-				//	RFC1123Z    = "Mon, 02 Jan 2006 15:04:05 -0700" // RFC1123 with numeric zone
-				modifiedRFC1123Z := "Mon, 2 Jan 2006 15:04:05 -0700"
-				pubDate, _ = time.Parse(modifiedRFC1123Z, toParse)
-			}
-			if (pubDate == time.Time{}) {
-				//	RFC1123Z    = "Mon, 02 Jan 2006 15:04:05 -0700" // RFC1123 with numeric zone
-				modifiedRFC1123Z := "Mon, 02 Jan 2006 15:04:05 -0700"
-				pubDate, _ = time.Parse(modifiedRFC1123Z, toParse)
-			}
-
+			pubDate := parsePubDate(obj.PubDate)
 			if (pubDate == time.Time{}) {
 				fmt.Printf("Cant format date : %s", obj.PubDate)
 			}
@@ -355,6 +538,222 @@ func AddPodcastItems(podcast *db.Podcast, newPodcast bool) error {
 	return err
 }
 
+// normalizeFeedURL lowercases the scheme and host of a feed url and drops any fragment.
+func normalizeFeedURL(raw string) string {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	parsed.Host = strings.ToLower(parsed.Host)
+	parsed.Fragment = ""
+	return parsed.String()
+}
+
+// feedURLKey reduces a feed url to the parts that identify the feed, so that
+// scheme, a www. prefix and a trailing slash don't make a second subscription.
+func feedURLKey(raw string) string {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || parsed.Host == "" {
+		return strings.ToLower(strings.TrimSpace(raw))
+	}
+	key := strings.TrimPrefix(strings.ToLower(parsed.Host), "www.") + strings.TrimRight(parsed.EscapedPath(), "/")
+	if parsed.RawQuery != "" {
+		key += "?" + parsed.RawQuery
+	}
+	return key
+}
+
+// findPodcastByFeedURL finds the subscription whose url is the same feed as feedURL,
+// returning gorm.ErrRecordNotFound when there is none.
+func findPodcastByFeedURL(feedURL string, podcast *db.Podcast) error {
+	err := db.GetPodcastByURL(feedURL, podcast)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	var podcasts []db.Podcast
+	if err := db.GetAllPodcasts(&podcasts, ""); err != nil {
+		return err
+	}
+	key := feedURLKey(feedURL)
+	for _, existing := range podcasts {
+		if feedURLKey(existing.URL) == key {
+			*podcast = existing
+			return nil
+		}
+	}
+	return gorm.ErrRecordNotFound
+}
+
+// updateMovedPodcastURL points the podcast at the location its feed permanently
+// redirected to, unless another subscription already uses that feed.
+func updateMovedPodcastURL(podcast *db.Podcast, movedTo string) {
+	newURL := normalizeFeedURL(movedTo)
+	if newURL == "" || newURL == podcast.URL {
+		return
+	}
+
+	var existing db.Podcast
+	err := findPodcastByFeedURL(newURL, &existing)
+	if err == nil {
+		if existing.ID != podcast.ID {
+			fmt.Printf("Feed %s moved to %s which is already subscribed\n", podcast.URL, newURL)
+			return
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return
+	}
+
+	if err := db.UpdatePodcastURL(podcast.ID, newURL); err != nil {
+		Logger.Errorw("Error updating moved podcast url: "+newURL, err)
+		return
+	}
+	fmt.Printf("Feed %s moved permanently to %s\n", podcast.URL, newURL)
+	podcast.URL = newURL
+}
+
+// getArchiveLink returns the RFC 5005 prev-archive link of a feed page, falling back
+// to a paged feed's next link, resolved against the page's own url.
+func getArchiveLink(data model.PodcastData, pageURL string) string {
+	href := ""
+	for _, link := range data.Channel.Link {
+		if link.Rel == "prev-archive" && link.Href != "" {
+			href = link.Href
+			break
+		}
+		if link.Rel == "next" && link.Href != "" && href == "" {
+			href = link.Href
+		}
+	}
+	if href == "" {
+		return ""
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return href
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// appendArchivePages follows the archive links of a paged feed for up to maxPages
+// further pages, adding items whose GUID has not been seen on an earlier page.
+func appendArchivePages(data *model.PodcastData, feedURL string, maxPages int) {
+	seenGuids := make(map[string]bool)
+	for _, item := range data.Channel.Item {
+		seenGuids[item.Guid.Text] = true
+	}
+	visited := map[string]bool{feedURL: true}
+
+	page := *data
+	pageURL := feedURL
+	for i := 0; i < maxPages; i++ {
+		next := getArchiveLink(page, pageURL)
+		if next == "" || visited[next] {
+			return
+		}
+		visited[next] = true
+
+		var err error
+		page, _, err = FetchURL(next)
+		if err != nil {
+			Logger.Errorw("Error fetching archive page: "+next, err)
+			return
+		}
+		pageURL = next
+
+		for _, item := range page.Channel.Item {
+			if seenGuids[item.Guid.Text] {
+				continue
+			}
+			seenGuids[item.Guid.Text] = true
+			data.Channel.Item = append(data.Channel.Item, item)
+		}
+	}
+}
+
+// Layouts tried in order when parsing an item's pubDate. Feeds are supposed to use RFC 822
+// but in practice anything goes, including dates without any zone at all.
+var pubDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	time.RFC822Z,
+	time.RFC822,
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC3339,
+	"Mon, 02 Jan 2006 15:04:05",
+	"Mon, 2 Jan 2006 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// Zone abbreviations defined by RFC 822, plus common ones seen in feeds. time.Parse only
+// knows their offset when the server happens to be in that zone, so they are resolved
+// explicitly. Ambiguous abbreviations such as IST are left out and read as UTC.
+var rfc822Zones = map[string]int{
+	"UT":   0,
+	"UTC":  0,
+	"GMT":  0,
+	"EST":  -5 * 60 * 60,
+	"EDT":  -4 * 60 * 60,
+	"CST":  -6 * 60 * 60,
+	"CDT":  -5 * 60 * 60,
+	"MST":  -7 * 60 * 60,
+	"MDT":  -6 * 60 * 60,
+	"PST":  -8 * 60 * 60,
+	"PDT":  -7 * 60 * 60,
+	"AKST": -9 * 60 * 60,
+	"AKDT": -8 * 60 * 60,
+	"HST":  -10 * 60 * 60,
+	"WET":  0,
+	"WEST": 1 * 60 * 60,
+	"BST":  1 * 60 * 60,
+	"CET":  1 * 60 * 60,
+	"CEST": 2 * 60 * 60,
+	"EET":  2 * 60 * 60,
+	"EEST": 3 * 60 * 60,
+	"MSK":  3 * 60 * 60,
+	"JST":  9 * 60 * 60,
+	"AWST": 8 * 60 * 60,
+	"ACST": 9*60*60 + 30*60,
+	"ACDT": 10*60*60 + 30*60,
+	"AEST": 10 * 60 * 60,
+	"AEDT": 11 * 60 * 60,
+	"NZST": 12 * 60 * 60,
+	"NZDT": 13 * 60 * 60,
+}
+
+// parsePubDate parses a feed date and normalizes it to UTC. Dates without an offset, or
+// with an unknown zone abbreviation, are assumed to be UTC. A zero time is returned when
+// nothing matches.
+func parsePubDate(raw string) time.Time {
+	toParse := strings.TrimSpace(raw)
+	for _, layout := range pubDateLayouts {
+		// Parsing in UTC keeps the server's own zone out of it
+		pubDate, err := time.ParseInLocation(layout, toParse, time.UTC)
+		if err != nil {
+			continue
+		}
+		if strings.Contains(layout, "MST") {
+			name, _ := pubDate.Zone()
+			if offset, ok := rfc822Zones[name]; ok {
+				pubDate = time.Date(pubDate.Year(), pubDate.Month(), pubDate.Day(),
+					pubDate.Hour(), pubDate.Minute(), pubDate.Second(), pubDate.Nanosecond(),
+					time.FixedZone(name, offset))
+			}
+		}
+		return pubDate.UTC()
+	}
+	return time.Time{}
+}
+
 func updateSizeFromUrl(itemUrlMap map[string]string) {
 
 	for id, url := range itemUrlMap {
@@ -368,22 +767,64 @@ func updateSizeFromUrl(itemUrlMap map[string]string) {
 
 }
 
-func UpdateAllFileSizes() {
+// updateRemoteFileSizes fills in FileSize for episodes that are not on disk from the
+// enclosure's Content-Length. Downloaded episodes are sized by VerifyFileSizes.
+func updateRemoteFileSizes() {
 	items, err := db.GetAllPodcastItemsWithoutSize()
 	if err != nil {
 		return
 	}
 	for _, item := range *items {
-		var size int64 = 1
 		if item.DownloadStatus == db.Downloaded {
-			size, _ = GetFileSize(item.DownloadPath)
-		} else {
-			size, _ = GetFileSizeFromUrl(item.FileURL)
+			continue
 		}
+		size, _ := GetFileSizeFromUrl(item.FileURL)
 		db.UpdatePodcastItemFileSize(item.ID, size)
 	}
 }
 
+// VerifyFileSizes re-stats every downloaded episode and fixes FileSize where the file on disk
+// has changed. Files that have gone missing are flagged the same way CheckMissingFiles does.
+// Episodes that are not downloaded and have no size yet get it from their enclosure.
+func VerifyFileSizes() (int, error) {
+	items, err := db.GetAllPodcastItemsAlreadyDownloaded()
+	if err != nil {
+		return 0, err
+	}
+	setting := db.GetOrCreateSetting()
+	corrected := 0
+	for _, item := range *items {
+		if item.DownloadPath == "" {
+			continue
+		}
+		size, err := GetFileSize(item.DownloadPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				markFileMissing(&item, setting)
+			}
+			continue
+		}
+		if size != item.FileSize {
+			if err := db.UpdatePodcastItemFileSize(item.ID, size); err != nil {
+				return corrected, err
+			}
+			corrected++
+		}
+	}
+	updateRemoteFileSizes()
+	return corrected, nil
+}
+
+// markFileMissing takes a downloaded episode whose file is gone off the downloaded list,
+// queueing it again unless the settings say deleted files stay deleted.
+func markFileMissing(item *db.PodcastItem, setting *db.Setting) {
+	if setting.DontDownloadDeletedFromDisk {
+		SetPodcastItemAsNotDownloaded(item.ID, db.Deleted)
+	} else {
+		SetPodcastItemAsNotDownloaded(item.ID, db.NotDownloaded)
+	}
+}
+
 func SetPodcastItemAsQueuedForDownload(id string) error {
 	var podcastItem db.PodcastItem
 	err := db.GetPodcastItemById(id, &podcastItem)
@@ -411,6 +852,41 @@ func DownloadMissingImages() error {
 	return nil
 }
 
+// CachePodcastImages keeps a local copy of every podcast's artwork so the UI doesn't depend
+// on the original host. Images are fetched again whenever the feed's image URL changes.
+func CachePodcastImages() error {
+	var podcasts []db.Podcast
+	err := db.GetAllPodcasts(&podcasts, "")
+	if err != nil {
+		return err
+	}
+	for _, podcast := range podcasts {
+		cachePodcastImage(&podcast)
+	}
+	return nil
+}
+
+func cachePodcastImage(podcast *db.Podcast) error {
+	if podcast.Image == "" {
+		return nil
+	}
+	if podcast.LocalImageURL == podcast.Image && FileExists(podcast.LocalImagePath) {
+		return nil
+	}
+	path, err := DownloadPodcastCoverImage(podcast.Image, GetPodcastFolderName(podcast), getPodcastFilePrefix(podcast))
+	if err != nil {
+		return err
+	}
+	if err := db.UpdatePodcastLocalImage(podcast.ID, path, podcast.Image); err != nil {
+		return err
+	}
+	// A new extension means a new file name, so the old copy would be left behind
+	if podcast.LocalImagePath != "" && podcast.LocalImagePath != path {
+		DeleteFile(podcast.LocalImagePath)
+	}
+	return nil
+}
+
 func downloadImageLocally(podcastItemId string) error {
 	var podcastItem db.PodcastItem
 	err := db.GetPodcastItemById(podcastItemId, &podcastItem)
@@ -418,7 +894,7 @@ func downloadImageLocally(podcastItemId string) error {
 		return err
 	}
 
-	path, err := DownloadImage(podcastItem.Image, podcastItem.ID, podcastItem.Podcast.Title)
+	path, err := DownloadImage(podcastItem.Image, podcastItem.ID, GetPodcastFolderName(&podcastItem.Podcast))
 	if err != nil {
 		return err
 	}
@@ -442,6 +918,35 @@ func SetPodcastItemBookmarkStatus(id string, bookmark bool) error {
 	return db.UpdatePodcastItem(&podcastItem)
 }
 
+// writeEpisodeTags writes ID3 tags from the episode's metadata to a downloaded MP3
+// when enabled in settings. Tagging is best effort and never fails the download.
+func writeEpisodeTags(item *db.PodcastItem, filePath string, setting *db.Setting) {
+	if !setting.WriteID3Tags || !strings.EqualFold(filepath.Ext(filePath), ".mp3") {
+		return
+	}
+
+	tag := id3.Tag{
+		Title:  item.Title,
+		Artist: item.Podcast.Author,
+		Album:  item.Podcast.Title,
+	}
+	if !item.PubDate.IsZero() {
+		tag.Year = strconv.Itoa(item.PubDate.Year())
+	}
+	if seq, err := db.GetEpisodeNumber(item.ID, item.PodcastID); err == nil {
+		tag.Track = strconv.Itoa(seq)
+	}
+	if item.Podcast.LocalImagePath != "" {
+		if image, err := ioutil.ReadFile(item.Podcast.LocalImagePath); err == nil {
+			tag.Picture = image
+		}
+	}
+
+	if err := id3.WriteFile(filePath, tag); err != nil {
+		Logger.Errorw("Error writing ID3 tags: "+filePath, err)
+	}
+}
+
 func SetPodcastItemAsDownloaded(id string, location string) error {
 	var podcastItem db.PodcastItem
 
@@ -459,9 +964,31 @@ func SetPodcastItemAsDownloaded(id string, location string) error {
 	podcastItem.DownloadDate = time.Now()
 	podcastItem.DownloadPath = location
 	podcastItem.DownloadStatus = db.Downloaded
+	podcastItem.DownloadAttempts = 0
+	podcastItem.LastDownloadError = ""
+
+	if isAutoMarkPlayed(podcastItem.PodcastID) {
+		podcastItem.IsPlayed = true
+	}
 
 	return db.UpdatePodcastItem(&podcastItem)
 }
+
+// isAutoMarkPlayed reports whether any tag on the podcast asks for its episodes to be
+// marked played as soon as they finish downloading.
+func isAutoMarkPlayed(podcastId string) bool {
+	tags, err := db.GetTagsByPodcastId(podcastId)
+	if err != nil {
+		return false
+	}
+	for _, tag := range *tags {
+		if tag.AutoMarkPlayed {
+			return true
+		}
+	}
+	return false
+}
+
 func SetPodcastItemAsNotDownloaded(id string, downloadStatus db.DownloadStatus) error {
 	var podcastItem db.PodcastItem
 	err := db.GetPodcastItemById(id, &podcastItem)
@@ -494,12 +1021,34 @@ func SetAllEpisodesToDownload(podcastId string) error {
 	return db.SetAllEpisodesToDownload(podcastId)
 }
 
+// QueueAllPodcastItems queues every skipped or deleted episode of the podcast for download.
+func QueueAllPodcastItems(podcastId string) (int, error) {
+	return db.QueueAllPodcastItems(podcastId)
+}
+
+// ResetAllPodcastItems marks the podcast's queued episodes as Deleted. With deleteFiles
+// the downloaded episodes are reset too and their files removed.
+func ResetAllPodcastItems(podcastId string, deleteFiles bool) (int, error) {
+	count, paths, err := db.ResetAllPodcastItems(podcastId, deleteFiles)
+	if err != nil {
+		return 0, err
+	}
+	for _, path := range paths {
+		DeleteFile(path)
+	}
+	return count, nil
+}
+
 func GetPodcastPrefix(item *db.PodcastItem, setting *db.Setting) string {
-	prefix := ""
+	prefix := getPodcastFilePrefix(&item.Podcast)
 	if setting.AppendEpisodeNumberToFileName {
 		seq, err := db.GetEpisodeNumber(item.ID, item.PodcastID)
 		if err == nil {
-			prefix = strconv.Itoa(seq)
+			if prefix == "" {
+				prefix = strconv.Itoa(seq)
+			} else {
+				prefix = prefix + "-" + strconv.Itoa(seq)
+			}
 		}
 	}
 	if setting.AppendDateToFileName {
@@ -522,7 +1071,7 @@ func DownloadMissingEpisodes() error {
 	db.Lock(JOB_NAME, 120)
 	setting := db.GetOrCreateSetting()
 
-	data, err := db.GetAllPodcastItemsToBeDownloaded()
+	data, err := getEpisodesToDownload(setting)
 
 	fmt.Println("Processing episodes: ", strconv.Itoa(len(*data)))
 	if err != nil {
@@ -533,7 +1082,14 @@ func DownloadMissingEpisodes() error {
 		wg.Add(1)
 		go func(item db.PodcastItem, setting db.Setting) {
 			defer wg.Done()
-			url, _ := Download(item.FileURL, item.Title, item.Podcast.Title, GetPodcastPrefix(&item, &setting))
+			url, err := Download(item.FileURL, item.Title, GetPodcastFolderName(&item.Podcast), GetPodcastPrefix(&item, &setting))
+			if err != nil {
+				// Leave the episode queued so the next run retries it
+				fmt.Println(err.Error())
+				db.RecordPodcastItemDownloadFailure(item.ID, err.Error())
+				return
+			}
+			writeEpisodeTags(&item, url, &setting)
 			SetPodcastItemAsDownloaded(item.ID, url)
 		}(item, *setting)
 
@@ -545,6 +1101,30 @@ func DownloadMissingEpisodes() error {
 	db.Unlock(JOB_NAME)
 	return nil
 }
+
+// getEpisodesToDownload returns the queued episodes, newest first, capped at
+// MaxNewEpisodesPerRefresh. Anything over the cap stays queued for the next run.
+// Episodes that failed fewer times come first so broken enclosures can't hold the
+// cap forever.
+func getEpisodesToDownload(setting *db.Setting) (*[]db.PodcastItem, error) {
+	data, err := db.GetAllPodcastItemsToBeDownloaded()
+	if err != nil {
+		return data, err
+	}
+	if setting.MaxNewEpisodesPerRefresh > 0 && len(*data) > setting.MaxNewEpisodesPerRefresh {
+		items := *data
+		sort.SliceStable(items, func(i, j int) bool {
+			if items[i].DownloadAttempts != items[j].DownloadAttempts {
+				return items[i].DownloadAttempts < items[j].DownloadAttempts
+			}
+			return items[i].PubDate.After(items[j].PubDate)
+		})
+		items = items[:setting.MaxNewEpisodesPerRefresh]
+		data = &items
+	}
+	return data, nil
+}
+
 func CheckMissingFiles() error {
 	data, err := db.GetAllPodcastItemsAlreadyDownloaded()
 	setting := db.GetOrCreateSetting()
@@ -556,11 +1136,7 @@ func CheckMissingFiles() error {
 	for _, item := range *data {
 		fileExists := FileExists(item.DownloadPath)
 		if !fileExists {
-			if setting.DontDownloadDeletedFromDisk {
-				SetPodcastItemAsNotDownloaded(item.ID, db.Deleted)
-			} else {
-				SetPodcastItemAsNotDownloaded(item.ID, db.NotDownloaded)
-			}
+			markFileMissing(&item, setting)
 		}
 	}
 	return nil
@@ -600,12 +1176,14 @@ func DownloadSingleEpisode(podcastItemId string) error {
 	setting := db.GetOrCreateSetting()
 	SetPodcastItemAsQueuedForDownload(podcastItemId)
 
-	url, err := Download(podcastItem.FileURL, podcastItem.Title, podcastItem.Podcast.Title, GetPodcastPrefix(&podcastItem, setting))
+	url, err := Download(podcastItem.FileURL, podcastItem.Title, GetPodcastFolderName(&podcastItem.Podcast), GetPodcastPrefix(&podcastItem, setting))
 
 	if err != nil {
 		fmt.Println(err.Error())
+		db.RecordPodcastItemDownloadFailure(podcastItem.ID, err.Error())
 		return err
 	}
+	writeEpisodeTags(&podcastItem, url, setting)
 	err = SetPodcastItemAsDownloaded(podcastItem.ID, url)
 
 	if setting.DownloadEpisodeImages {
@@ -629,6 +1207,7 @@ func RefreshEpisodes() error {
 		}
 		AddPodcastItems(&item, isNewPodcast)
 	}
+	db.UpdateLastRefreshAt(time.Now())
 	//	setting := db.GetOrCreateSetting()
 
 	go DownloadMissingEpisodes()
@@ -660,6 +1239,80 @@ func DeletePodcastEpisodes(id string) error {
 	return nil
 
 }
+
+// DeduplicatePodcastItems removes episodes of a podcast that share a GUID, or for episodes
+// without one the same enclosure url. The survivor is the downloaded copy if there is one,
+// otherwise the oldest record.
+func DeduplicatePodcastItems(podcastId string) (int, error) {
+	var podcastItems []db.PodcastItem
+	err := db.GetAllPodcastItemsByPodcastId(podcastId, &podcastItems)
+	if err != nil {
+		return 0, err
+	}
+
+	itemsByKey := make(map[string][]db.PodcastItem)
+	for _, item := range podcastItems {
+		key := duplicateKey(item)
+		if key == "" {
+			continue
+		}
+		itemsByKey[key] = append(itemsByKey[key], item)
+	}
+
+	removed := 0
+	for _, items := range itemsByKey {
+		if len(items) < 2 {
+			continue
+		}
+		keep := items[0]
+		for _, item := range items[1:] {
+			if isPreferredDuplicate(item, keep) {
+				keep = item
+			}
+		}
+		for _, item := range items {
+			if item.ID == keep.ID {
+				continue
+			}
+			if item.DownloadPath != "" && item.DownloadPath != keep.DownloadPath {
+				DeleteFile(item.DownloadPath)
+			}
+			if item.LocalImage != "" && item.LocalImage != keep.LocalImage {
+				DeleteFile(item.LocalImage)
+			}
+			if err := db.DeletePodcastItemById(item.ID); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// duplicateKey identifies an episode by GUID, falling back to the enclosure url and
+// then title and date for feeds that leave the GUID out.
+func duplicateKey(item db.PodcastItem) string {
+	switch {
+	case item.GUID != "":
+		return "guid:" + item.GUID
+	case item.FileURL != "":
+		return "url:" + item.FileURL
+	case item.Title != "" && !item.PubDate.IsZero():
+		return "title:" + item.Title + "|" + item.PubDate.UTC().Format(time.RFC3339)
+	default:
+		return ""
+	}
+}
+
+func isPreferredDuplicate(candidate, current db.PodcastItem) bool {
+	candidateDownloaded := candidate.DownloadStatus == db.Downloaded
+	currentDownloaded := current.DownloadStatus == db.Downloaded
+	if candidateDownloaded != currentDownloaded {
+		return candidateDownloaded
+	}
+	return candidate.CreatedAt.Before(current.CreatedAt)
+}
+
 func DeletePodcast(id string, deleteFiles bool) error {
 	var podcast db.Podcast
 
@@ -685,9 +1338,13 @@ func DeletePodcast(id string, deleteFiles bool) error {
 
 	}
 
-	err = deletePodcastFolder(podcast.Title)
-	if err != nil {
-		return err
+	db.DeletePodcastPeopleAndFundingByPodcastId(id)
+
+	if !isPodcastFolderShared(&podcast) {
+		err = deletePodcastFolder(GetPodcastFolderName(&podcast))
+		if err != nil {
+			return err
+		}
 	}
 
 	err = db.DeletePodcastById(id)
@@ -710,24 +1367,84 @@ func DeleteTag(id string) error {
 func makeQuery(url string) ([]byte, error) {
 	//link := "https://www.goodreads.com/search/index.xml?q=Good%27s+Omens&key=" + "jCmNlIXjz29GoB8wYsrd0w"
 	//link := "https://www.goodreads.com/search/index.xml?key=jCmNlIXjz29GoB8wYsrd0w&q=Ender%27s+Game"
+	body, _, err := makeQueryFollowingRedirects(url)
+	return body, err
+}
+
+// makeQueryFollowingRedirects fetches url and, when it was only reached through
+// permanent (301/308) redirects, also returns the final location.
+func makeQueryFollowingRedirects(url string) ([]byte, string, error) {
 	fmt.Println(url)
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	// Setting Accept-Encoding ourselves turns off net/http's transparent
+	// decompression, so the body is decoded in decodeResponseBody below.
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	redirected := false
+	permanent := true
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			redirected = true
+			if req.Response == nil || (req.Response.StatusCode != http.StatusMovedPermanently && req.Response.StatusCode != http.StatusPermanentRedirect) {
+				permanent = false
+			}
+			return nil
+		},
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	defer resp.Body.Close()
 	fmt.Println("Response status:", resp.Status)
 	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	movedTo := ""
+	if redirected && permanent && resp.StatusCode == http.StatusOK {
+		movedTo = resp.Request.URL.String()
+	}
 
-	return body, nil
+	body, err = decodeResponseBody(body, resp.Header.Get("Content-Encoding"))
+	return body, movedTo, err
 
 }
+
+// decodeResponseBody decompresses body according to the Content-Encoding header.
+// Some servers compress feeds even when the client did not ask for it.
+func decodeResponseBody(body []byte, contentEncoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	case "deflate":
+		// deflate should be zlib wrapped, but plenty of servers send raw deflate
+		if reader, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			defer reader.Close()
+			return ioutil.ReadAll(reader)
+		}
+		reader := flate.NewReader(bytes.NewReader(body))
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	default:
+		return body, nil
+	}
+}
 func GetSearchFromGpodder(pod model.GPodcast) *model.CommonSearchResultModel {
 	p := new(model.CommonSearchResultModel)
 	p.URL = pod.URL
@@ -764,7 +1481,9 @@ func GetSearchFromPodcastIndex(pod *podcastindex.Podcast) *model.CommonSearchRes
 
 func UpdateSettings(downloadOnAdd bool, initialDownloadCount int, autoDownload bool,
 	appendDateToFileName bool, appendEpisodeNumberToFileName bool, darkMode bool, downloadEpisodeImages bool,
-	generateNFOFile bool, dontDownloadDeletedFromDisk bool, baseUrl string, maxDownloadConcurrency int, userAgent string) error {
+	generateNFOFile bool, dontDownloadDeletedFromDisk bool, baseUrl string, maxDownloadConcurrency int, userAgent string,
+	maxNewEpisodesPerRefresh int, maxArchivePages int, writeID3Tags bool,
+	defaultPageSize int) error {
 	setting := db.GetOrCreateSetting()
 
 	setting.AutoDownload = autoDownload
@@ -779,6 +1498,11 @@ func UpdateSettings(downloadOnAdd bool, initialDownloadCount int, autoDownload b
 	setting.BaseUrl = baseUrl
 	setting.MaxDownloadConcurrency = maxDownloadConcurrency
 	setting.UserAgent = userAgent
+	setting.MaxNewEpisodesPerRefresh = maxNewEpisodesPerRefresh
+	setting.MaxArchivePages = maxArchivePages
+	setting.WriteID3Tags = writeID3Tags
+	setting.DefaultPageSize = defaultPageSize
+	model.SetDefaultPageSize(defaultPageSize)
 
 	return db.UpdateSettings(setting)
 }
@@ -787,15 +1511,16 @@ func UnlockMissedJobs() {
 	db.UnlockMissedJobs()
 }
 
-func AddTag(label, description string) (db.Tag, error) {
+func AddTag(label, description string, autoMarkPlayed bool) (db.Tag, error) {
 
 	tag, err := db.GetTagByLabel(label)
 
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 
 		tag := db.Tag{
-			Label:       label,
-			Description: description,
+			Label:          label,
+			Description:    description,
+			AutoMarkPlayed: autoMarkPlayed,
 		}
 
 		err = db.CreateTag(&tag)
@@ -806,6 +1531,68 @@ func AddTag(label, description string) (db.Tag, error) {
 
 }
 
+// getPodcastsSharingFolder returns the other podcasts that store their files in the
+// same folder, which happens when several podcasts use one FolderOverride.
+func getPodcastsSharingFolder(podcast *db.Podcast) ([]db.Podcast, error) {
+	var podcasts []db.Podcast
+	if err := db.GetAllPodcasts(&podcasts, ""); err != nil {
+		return nil, err
+	}
+	folder := cleanFileName(GetPodcastFolderName(podcast))
+	var sharing []db.Podcast
+	for _, other := range podcasts {
+		if other.ID != podcast.ID && cleanFileName(GetPodcastFolderName(&other)) == folder {
+			sharing = append(sharing, other)
+		}
+	}
+	return sharing, nil
+}
+
+func isPodcastFolderShared(podcast *db.Podcast) bool {
+	sharing, err := getPodcastsSharingFolder(podcast)
+	return err != nil || len(sharing) > 0
+}
+
+// getPodcastFilePrefix returns a prefix that keeps the podcast's files from
+// overwriting those of other podcasts in a shared folder, or "" if the folder is its own.
+// The title is used where it is enough to tell the podcasts apart, the id otherwise.
+func getPodcastFilePrefix(podcast *db.Podcast) string {
+	sharing, err := getPodcastsSharingFolder(podcast)
+	if err != nil || len(sharing) == 0 {
+		return ""
+	}
+	slug := getPodcastSlug(podcast)
+	if slug == "" {
+		return podcast.ID
+	}
+	for _, other := range sharing {
+		if getPodcastSlug(&other) == slug {
+			return podcast.ID
+		}
+	}
+	return slug
+}
+
+func SetPodcastFolderOverride(id string, folderOverride string) error {
+	var podcast db.Podcast
+	err := db.GetPodcastById(id, &podcast)
+	if err != nil {
+		return err
+	}
+
+	return db.UpdatePodcastFolderOverride(id, folderOverride)
+}
+
+func SetPodcastFollowArchiveLinks(id string, followArchiveLinks bool) error {
+	var podcast db.Podcast
+	err := db.GetPodcastById(id, &podcast)
+	if err != nil {
+		return err
+	}
+
+	return db.UpdatePodcastFollowArchiveLinks(id, followArchiveLinks)
+}
+
 func TogglePodcastPause(id string, isPaused bool) error {
 	var podcast db.Podcast
 	err := db.GetPodcastById(id, &podcast)
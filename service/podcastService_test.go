@@ -0,0 +1,1155 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allenhutchison/podgrab/db"
+	"github.com/allenhutchison/podgrab/internal/id3"
+	"github.com/allenhutchison/podgrab/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyFileSizes(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	dir := t.TempDir()
+	podcast, err := db.CreateTestPodcast(testDB, "Test Podcast")
+	require.NoError(t, err)
+
+	correctPath := filepath.Join(dir, "correct.mp3")
+	require.NoError(t, ioutil.WriteFile(correctPath, make([]byte, 100), 0644))
+	correct, err := db.CreateTestPodcastItem(testDB, podcast, "Correct", db.Downloaded)
+	require.NoError(t, err)
+	correct.DownloadPath = correctPath
+	correct.FileSize = 100
+	require.NoError(t, testDB.Save(correct).Error)
+
+	driftedPath := filepath.Join(dir, "drifted.mp3")
+	require.NoError(t, ioutil.WriteFile(driftedPath, make([]byte, 250), 0644))
+	drifted, err := db.CreateTestPodcastItem(testDB, podcast, "Drifted", db.Downloaded)
+	require.NoError(t, err)
+	drifted.DownloadPath = driftedPath
+	drifted.FileSize = 100
+	require.NoError(t, testDB.Save(drifted).Error)
+
+	missing, err := db.CreateTestPodcastItem(testDB, podcast, "Missing", db.Downloaded)
+	require.NoError(t, err)
+	missing.DownloadPath = filepath.Join(dir, "missing.mp3")
+	missing.FileSize = 100
+	require.NoError(t, testDB.Save(missing).Error)
+
+	noPath, err := db.CreateTestPodcastItem(testDB, podcast, "No Path", db.Downloaded)
+	require.NoError(t, err)
+
+	corrected, err := VerifyFileSizes()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, corrected)
+
+	var item db.PodcastItem
+	require.NoError(t, db.GetPodcastItemById(correct.ID, &item))
+	assert.Equal(t, int64(100), item.FileSize)
+	assert.Equal(t, db.Downloaded, item.DownloadStatus)
+
+	require.NoError(t, db.GetPodcastItemById(drifted.ID, &item))
+	assert.Equal(t, int64(250), item.FileSize)
+	assert.Equal(t, db.Downloaded, item.DownloadStatus)
+
+	require.NoError(t, db.GetPodcastItemById(missing.ID, &item))
+	assert.Equal(t, db.NotDownloaded, item.DownloadStatus)
+	assert.Equal(t, "", item.DownloadPath)
+
+	require.NoError(t, db.GetPodcastItemById(noPath.ID, &item))
+	assert.Equal(t, db.Downloaded, item.DownloadStatus)
+
+	t.Run("episodes not on disk are sized from the enclosure", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "4096")
+		}))
+		defer server.Close()
+
+		remote, err := db.CreateTestPodcastItem(testDB, podcast, "Remote", db.NotDownloaded)
+		require.NoError(t, err)
+		remote.FileURL = server.URL + "/remote.mp3"
+		require.NoError(t, testDB.Save(remote).Error)
+
+		_, err = VerifyFileSizes()
+		assert.NoError(t, err)
+		require.NoError(t, db.GetPodcastItemById(remote.ID, &item))
+		assert.Equal(t, int64(4096), item.FileSize)
+	})
+}
+
+// testFeedItem renders a minimal RSS item for feeds served from httptest servers.
+func testFeedItem(guid, title, pubDate string) string {
+	return fmt.Sprintf(`<item><title>%s</title><guid>%s</guid><pubDate>%s</pubDate><enclosure url="http://example.com/%s.mp3" type="audio/mpeg"/></item>`, title, guid, pubDate, guid)
+}
+
+func testFeed(items ...string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>Test Feed</title>` + strings.Join(items, "") + `</channel></rss>`
+}
+
+func newFeedServer(t *testing.T, feed string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprint(w, feed)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestParsePubDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected time.Time
+	}{
+		{
+			name:     "RFC1123Z with positive offset",
+			input:    "Mon, 15 Jan 2024 01:30:00 +0200",
+			expected: time.Date(2024, 1, 14, 23, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC1123Z with negative offset",
+			input:    "Mon, 15 Jan 2024 22:00:00 -0500",
+			expected: time.Date(2024, 1, 16, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC1123Z with single digit day",
+			input:    "Mon, 1 Jan 2024 10:00:00 +0530",
+			expected: time.Date(2024, 1, 1, 4, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC1123 with GMT",
+			input:    "Mon, 15 Jan 2024 12:00:00 GMT",
+			expected: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC1123 with US zone abbreviation",
+			input:    "Mon, 15 Jan 2024 21:00:00 PST",
+			expected: time.Date(2024, 1, 16, 5, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC822 with zone abbreviation",
+			input:    "15 Jan 24 20:00 EDT",
+			expected: time.Date(2024, 1, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC1123 with European zone abbreviation",
+			input:    "Mon, 15 Jul 2024 09:00:00 BST",
+			expected: time.Date(2024, 7, 15, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC1123 with Central European summer time",
+			input:    "Mon, 15 Jul 2024 09:00:00 CEST",
+			expected: time.Date(2024, 7, 15, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC1123 with Australian zone abbreviation",
+			input:    "Mon, 15 Jan 2024 09:00:00 AEST",
+			expected: time.Date(2024, 1, 14, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "unknown zone abbreviation is treated as UTC",
+			input:    "Mon, 15 Jan 2024 09:00:00 IST",
+			expected: time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "RFC822Z with numeric offset",
+			input:    "15 Jan 24 20:00 -0100",
+			expected: time.Date(2024, 1, 15, 21, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "date without offset is treated as UTC",
+			input:    "Mon, 15 Jan 2024 23:45:00",
+			expected: time.Date(2024, 1, 15, 23, 45, 0, 0, time.UTC),
+		},
+		{
+			name:     "surrounding whitespace",
+			input:    "  Mon, 15 Jan 2024 12:00:00 +0000\n",
+			expected: time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "unparseable date",
+			input:    "yesterday",
+			expected: time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := parsePubDate(tt.input)
+			assert.True(t, tt.expected.Equal(result), "expected %v, got %v", tt.expected, result)
+			if (tt.expected != time.Time{}) {
+				assert.Equal(t, time.UTC, result.Location())
+			}
+		})
+	}
+
+	t.Run("server zone does not change the result", func(t *testing.T) {
+		kolkata, err := time.LoadLocation("Asia/Kolkata")
+		if err != nil {
+			t.Skip("time zone database not available")
+		}
+		local := time.Local
+		time.Local = kolkata
+		defer func() { time.Local = local }()
+
+		result := parsePubDate("Mon, 15 Jan 2024 09:00:00 IST")
+		assert.True(t, time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC).Equal(result), "got %v", result)
+	})
+}
+
+func TestAddPodcastItemsStoresPubDateInUTC(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	server := newFeedServer(t, testFeed(
+		testFeedItem("ep-1", "Episode 1", "Mon, 15 Jan 2024 23:30:00 -0800"),
+		testFeedItem("ep-2", "Episode 2", "Tue, 16 Jan 2024 00:15:00"),
+	))
+
+	podcast, err := db.CreateTestPodcast(testDB, "Test Podcast")
+	require.NoError(t, err)
+	podcast.URL = server.URL
+	require.NoError(t, testDB.Save(podcast).Error)
+
+	require.NoError(t, AddPodcastItems(podcast, false))
+
+	var item db.PodcastItem
+	require.NoError(t, db.GetPodcastItemByPodcastIdAndGUID(podcast.ID, "ep-1", &item))
+	assert.True(t, time.Date(2024, 1, 16, 7, 30, 0, 0, time.UTC).Equal(item.PubDate), "got %v", item.PubDate)
+
+	require.NoError(t, db.GetPodcastItemByPodcastIdAndGUID(podcast.ID, "ep-2", &item))
+	assert.True(t, time.Date(2024, 1, 16, 0, 15, 0, 0, time.UTC).Equal(item.PubDate), "got %v", item.PubDate)
+}
+
+func TestDeduplicatePodcastItems(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	dir := t.TempDir()
+	podcast, err := db.CreateTestPodcast(testDB, "Test Podcast")
+	require.NoError(t, err)
+	other, err := db.CreateTestPodcast(testDB, "Other Podcast")
+	require.NoError(t, err)
+
+	keptPath := filepath.Join(dir, "kept.mp3")
+	require.NoError(t, ioutil.WriteFile(keptPath, []byte("kept"), 0644))
+	duplicatePath := filepath.Join(dir, "duplicate.mp3")
+	require.NoError(t, ioutil.WriteFile(duplicatePath, []byte("duplicate"), 0644))
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	createItem := func(podcastId, guid string, status db.DownloadStatus, downloadPath string, createdAt time.Time) *db.PodcastItem {
+		item := &db.PodcastItem{
+			PodcastID:      podcastId,
+			Title:          guid,
+			GUID:           guid,
+			DownloadStatus: status,
+			DownloadPath:   downloadPath,
+		}
+		item.CreatedAt = createdAt
+		require.NoError(t, testDB.Create(item).Error)
+		return item
+	}
+
+	// Downloaded wins over an older record that was never downloaded.
+	createItem(podcast.ID, "dup-1", db.NotDownloaded, "", base)
+	downloaded := createItem(podcast.ID, "dup-1", db.Downloaded, keptPath, base.Add(time.Hour))
+	createItem(podcast.ID, "dup-1", db.Downloaded, duplicatePath, base.Add(2*time.Hour))
+	createItem(podcast.ID, "dup-1", db.Downloaded, keptPath, base.Add(3*time.Hour))
+
+	// Without a downloaded copy the earliest record wins.
+	oldest := createItem(podcast.ID, "dup-2", db.NotDownloaded, "", base)
+	createItem(podcast.ID, "dup-2", db.Deleted, "", base.Add(time.Hour))
+
+	unique := createItem(podcast.ID, "unique", db.NotDownloaded, "", base)
+	otherItem := createItem(other.ID, "dup-2", db.NotDownloaded, "", base)
+
+	removed, err := DeduplicatePodcastItems(podcast.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, removed)
+
+	var items []db.PodcastItem
+	require.NoError(t, db.GetAllPodcastItemsByPodcastId(podcast.ID, &items))
+	survivors := make(map[string]string)
+	for _, item := range items {
+		survivors[item.GUID] = item.ID
+	}
+	assert.Len(t, items, 3)
+	assert.Equal(t, downloaded.ID, survivors["dup-1"])
+	assert.Equal(t, oldest.ID, survivors["dup-2"])
+	assert.Equal(t, unique.ID, survivors["unique"])
+
+	assert.True(t, FileExists(keptPath))
+	assert.False(t, FileExists(duplicatePath))
+
+	var item db.PodcastItem
+	assert.NoError(t, db.GetPodcastItemById(otherItem.ID, &item))
+}
+
+func TestDeduplicatePodcastItemsWithoutGUID(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	podcast, err := db.CreateTestPodcast(testDB, "Test Podcast")
+	require.NoError(t, err)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	created := 0
+	createItem := func(title, fileURL string, pubDate time.Time, status db.DownloadStatus) *db.PodcastItem {
+		item := &db.PodcastItem{
+			PodcastID:      podcast.ID,
+			Title:          title,
+			FileURL:        fileURL,
+			PubDate:        pubDate,
+			DownloadStatus: status,
+		}
+		created++
+		item.CreatedAt = base.Add(time.Duration(created) * time.Minute)
+		require.NoError(t, testDB.Create(item).Error)
+		return item
+	}
+
+	// Same enclosure, e.g. once from the feed and once from an archive page
+	createItem("Episode 1", "http://example.com/1.mp3", base, db.NotDownloaded)
+	byURL := createItem("Episode 1 (archive)", "http://example.com/1.mp3", base, db.Downloaded)
+
+	// No enclosure url either, so title and date decide
+	byTitle := createItem("Episode 2", "", base.Add(24*time.Hour), db.NotDownloaded)
+	createItem("Episode 2", "", base.Add(24*time.Hour), db.Deleted)
+
+	// Same title on another day is a different episode
+	rerun := createItem("Episode 2", "", base.Add(48*time.Hour), db.NotDownloaded)
+	distinct := createItem("Episode 3", "http://example.com/3.mp3", base, db.NotDownloaded)
+
+	removed, err := DeduplicatePodcastItems(podcast.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	var items []db.PodcastItem
+	require.NoError(t, db.GetAllPodcastItemsByPodcastId(podcast.ID, &items))
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	assert.ElementsMatch(t, []string{byURL.ID, byTitle.ID, rerun.ID, distinct.ID}, ids)
+}
+
+func TestSetPodcastItemAsDownloadedAutoMarksPlayed(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	dir := t.TempDir()
+	archived, err := db.CreateTestPodcast(testDB, "Archived")
+	require.NoError(t, err)
+	regular, err := db.CreateTestPodcast(testDB, "Regular")
+	require.NoError(t, err)
+	untagged, err := db.CreateTestPodcast(testDB, "Untagged")
+	require.NoError(t, err)
+
+	archiveTag, err := AddTag("archive", "", true)
+	require.NoError(t, err)
+	newsTag, err := AddTag("news", "", false)
+	require.NoError(t, err)
+	require.NoError(t, db.AddTagToPodcast(archived.ID, archiveTag.ID))
+	require.NoError(t, db.AddTagToPodcast(archived.ID, newsTag.ID))
+	require.NoError(t, db.AddTagToPodcast(regular.ID, newsTag.ID))
+
+	tests := []struct {
+		name           string
+		podcast        *db.Podcast
+		expectedPlayed bool
+	}{
+		{"podcast with auto-mark tag", archived, true},
+		{"podcast with regular tag", regular, false},
+		{"podcast without tags", untagged, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item, err := db.CreateTestPodcastItem(testDB, tt.podcast, tt.podcast.Title+" Episode", db.NotDownloaded)
+			require.NoError(t, err)
+			location := filepath.Join(dir, tt.podcast.Title+".mp3")
+			require.NoError(t, ioutil.WriteFile(location, []byte("audio"), 0644))
+
+			require.NoError(t, SetPodcastItemAsDownloaded(item.ID, location))
+
+			var saved db.PodcastItem
+			require.NoError(t, db.GetPodcastItemById(item.ID, &saved))
+			assert.Equal(t, db.Downloaded, saved.DownloadStatus)
+			assert.Equal(t, tt.expectedPlayed, saved.IsPlayed)
+		})
+	}
+}
+
+func TestCachePodcastImages(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	t.Setenv("DATA", t.TempDir())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cover.jpg":
+			fmt.Fprint(w, "jpeg-data")
+		case "/new-cover.png":
+			fmt.Fprint(w, "png-data")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	podcast, err := db.CreateTestPodcast(testDB, "Test Podcast")
+	require.NoError(t, err)
+	podcast.Image = server.URL + "/cover.jpg"
+	require.NoError(t, testDB.Save(podcast).Error)
+
+	var saved db.Podcast
+	t.Run("image is cached and column set", func(t *testing.T) {
+		require.NoError(t, CachePodcastImages())
+		require.NoError(t, db.GetPodcastById(podcast.ID, &saved))
+		assert.NotEmpty(t, saved.LocalImagePath)
+		assert.Equal(t, podcast.Image, saved.LocalImageURL)
+		content, err := ioutil.ReadFile(saved.LocalImagePath)
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg-data", string(content))
+	})
+
+	t.Run("image is refetched when the url changes", func(t *testing.T) {
+		previousPath := saved.LocalImagePath
+		require.NoError(t, db.UpdatePodcastImage(podcast.ID, server.URL+"/new-cover.png"))
+		require.NoError(t, CachePodcastImages())
+		require.NoError(t, db.GetPodcastById(podcast.ID, &saved))
+		assert.NoFileExists(t, previousPath, "the old copy is removed")
+		assert.Equal(t, server.URL+"/new-cover.png", saved.LocalImageURL)
+		assert.Equal(t, ".png", filepath.Ext(saved.LocalImagePath))
+		content, err := ioutil.ReadFile(saved.LocalImagePath)
+		require.NoError(t, err)
+		assert.Equal(t, "png-data", string(content))
+	})
+
+	t.Run("failed download keeps remote url as fallback", func(t *testing.T) {
+		previousPath := saved.LocalImagePath
+		require.NoError(t, db.UpdatePodcastImage(podcast.ID, server.URL+"/missing.jpg"))
+		require.NoError(t, CachePodcastImages())
+		require.NoError(t, db.GetPodcastById(podcast.ID, &saved))
+		assert.Equal(t, server.URL+"/missing.jpg", saved.Image)
+		assert.NotEqual(t, saved.Image, saved.LocalImageURL)
+		assert.Equal(t, previousPath, saved.LocalImagePath)
+	})
+}
+
+func TestAddPodcastStoresPeopleAndFunding(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	feed := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:podcast="https://podcastindex.org/namespace/1.0"><channel><title>Test Feed</title>
+<podcast:person role="host" href="https://example.com/alice" img="https://example.com/alice.jpg">Alice</podcast:person>
+<podcast:person role="guest" group="cast">Bob</podcast:person>
+<podcast:funding url="https://example.com/support">Support the show</podcast:funding>
+</channel></rss>`
+	server := newFeedServer(t, feed)
+
+	added, err := AddPodcast(server.URL)
+	require.NoError(t, err)
+
+	var podcast db.Podcast
+	require.NoError(t, db.GetPodcastById(added.ID, &podcast))
+	require.Len(t, podcast.People, 2)
+	names := map[string]db.PodcastPerson{}
+	for _, person := range podcast.People {
+		names[person.Name] = person
+	}
+	assert.Equal(t, "host", names["Alice"].Role)
+	assert.Equal(t, "https://example.com/alice", names["Alice"].Href)
+	assert.Equal(t, "https://example.com/alice.jpg", names["Alice"].Img)
+	assert.Equal(t, "guest", names["Bob"].Role)
+	assert.Equal(t, "cast", names["Bob"].Group)
+
+	require.Len(t, podcast.Funding, 1)
+	assert.Equal(t, "https://example.com/support", podcast.Funding[0].URL)
+	assert.Equal(t, "Support the show", podcast.Funding[0].Text)
+}
+
+func TestAddPodcastItemsUpdatesPeopleAndFunding(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	server := newFeedServer(t, testFeed(testFeedItem("ep-1", "Episode 1", "Mon, 15 Jan 2024 10:00:00 GMT")))
+
+	podcast, err := db.CreateTestPodcast(testDB, "Test Podcast")
+	require.NoError(t, err)
+	podcast.URL = server.URL
+	require.NoError(t, testDB.Save(podcast).Error)
+	require.NoError(t, db.ReplacePodcastPeopleAndFunding(podcast.ID,
+		[]db.PodcastPerson{{Name: "Former Host", Role: "host"}},
+		[]db.PodcastFunding{{URL: "https://example.com/old"}}))
+
+	require.NoError(t, AddPodcastItems(podcast, false))
+
+	var saved db.Podcast
+	require.NoError(t, db.GetPodcastById(podcast.ID, &saved))
+	assert.Empty(t, saved.People)
+	assert.Empty(t, saved.Funding)
+}
+
+func TestFetchURLDecodesCompressedFeeds(t *testing.T) {
+	feed := testFeed(
+		testFeedItem("ep-1", "Episode 1", "Mon, 15 Jan 2024 10:00:00 GMT"),
+		testFeedItem("ep-2", "Episode 2", "Tue, 16 Jan 2024 10:00:00 GMT"),
+	)
+
+	compress := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		_, err := io.WriteString(w, feed)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+	}{
+		{"identity", "", []byte(feed)},
+		{"gzip", "gzip", compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })},
+		{"zlib deflate", "deflate", compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })},
+		{"raw deflate", "deflate", compress(func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var acceptEncoding string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+				w.Header().Set("Content-Type", "application/rss+xml")
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			data, body, err := FetchURL(server.URL)
+			require.NoError(t, err)
+			assert.Contains(t, acceptEncoding, "gzip")
+			assert.Equal(t, feed, string(body))
+			assert.Equal(t, "Test Feed", data.Channel.Title)
+			require.Len(t, data.Channel.Item, 2)
+			assert.Equal(t, "ep-1", data.Channel.Item[0].Guid.Text)
+			assert.Equal(t, "Episode 2", data.Channel.Item[1].Title)
+		})
+	}
+}
+
+func TestGetEpisodesToDownloadCapsNewEpisodes(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	var items []string
+	for i := 1; i <= 10; i++ {
+		pubDate := time.Date(2024, 1, i, 10, 0, 0, 0, time.UTC).Format(time.RFC1123Z)
+		items = append(items, testFeedItem(fmt.Sprintf("ep-%d", i), fmt.Sprintf("Episode %d", i), pubDate))
+	}
+	server := newFeedServer(t, testFeed(items...))
+
+	podcast, err := db.CreateTestPodcast(testDB, "Test Podcast")
+	require.NoError(t, err)
+	podcast.URL = server.URL
+	require.NoError(t, testDB.Save(podcast).Error)
+
+	setting := db.GetOrCreateSetting()
+	setting.AutoDownload = true
+	setting.MaxNewEpisodesPerRefresh = 3
+	require.NoError(t, db.UpdateSettings(setting))
+
+	require.NoError(t, AddPodcastItems(podcast, false))
+
+	queued, err := getEpisodesToDownload(setting)
+	require.NoError(t, err)
+	require.Len(t, *queued, 3)
+	assert.Equal(t, "Episode 10", (*queued)[0].Title)
+	assert.Equal(t, "Episode 9", (*queued)[1].Title)
+	assert.Equal(t, "Episode 8", (*queued)[2].Title)
+
+	// The rest stay queued for later runs
+	remaining, err := db.GetAllPodcastItemsToBeDownloaded()
+	require.NoError(t, err)
+	assert.Len(t, *remaining, 10)
+
+	t.Run("zero means unlimited", func(t *testing.T) {
+		setting.MaxNewEpisodesPerRefresh = 0
+		queued, err := getEpisodesToDownload(setting)
+		require.NoError(t, err)
+		assert.Len(t, *queued, 10)
+	})
+}
+
+func TestGetEpisodesToDownloadSkipsPastFailingEpisodes(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	t.Setenv("DATA", t.TempDir())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/good.mp3" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("audio"))
+	}))
+	defer server.Close()
+
+	podcast, err := db.CreateTestPodcast(testDB, "Test Podcast")
+	require.NoError(t, err)
+	newItem := func(title string, fileName string, pubDate time.Time) *db.PodcastItem {
+		item, err := db.CreateTestPodcastItem(testDB, podcast, title, db.NotDownloaded)
+		require.NoError(t, err)
+		item.FileURL = server.URL + "/" + fileName
+		item.PubDate = pubDate
+		require.NoError(t, testDB.Save(item).Error)
+		return item
+	}
+	good := newItem("Old Episode", "good.mp3", time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC))
+	for i := 1; i <= 3; i++ {
+		newItem(fmt.Sprintf("Broken %d", i), fmt.Sprintf("broken-%d.mp3", i), time.Date(2024, 2, i, 10, 0, 0, 0, time.UTC))
+	}
+
+	setting := db.GetOrCreateSetting()
+	setting.MaxNewEpisodesPerRefresh = 3
+	require.NoError(t, db.UpdateSettings(setting))
+
+	// The first run tries the three newest episodes, which all fail
+	require.NoError(t, DownloadMissingEpisodes())
+	var saved db.PodcastItem
+	require.NoError(t, db.GetPodcastItemById(good.ID, &saved))
+	assert.Equal(t, db.NotDownloaded, saved.DownloadStatus)
+
+	// The next run gets to the older episode that has not failed yet
+	require.NoError(t, DownloadMissingEpisodes())
+	require.NoError(t, db.GetPodcastItemById(good.ID, &saved))
+	assert.Equal(t, db.Downloaded, saved.DownloadStatus)
+	assert.FileExists(t, saved.DownloadPath)
+}
+
+func TestDownloadUsesPodcastFolderOverride(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	dataDir := t.TempDir()
+	t.Setenv("DATA", dataDir)
+	server := newFeedServer(t, "audio")
+
+	tests := []struct {
+		name           string
+		title          string
+		folderOverride string
+		expectedFolder string
+	}{
+		{"no override uses title", "My Show", "", "My Show"},
+		{"override replaces title", "Gadget Hour", "Tech", "tech"},
+		{"override cannot escape data dir", "Escape Show", "../../etc", "etc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			podcast, err := db.CreateTestPodcast(testDB, tt.title)
+			require.NoError(t, err)
+			require.NoError(t, db.UpdatePodcastFolderOverride(podcast.ID, tt.folderOverride))
+
+			item, err := db.CreateTestPodcastItem(testDB, podcast, "Episode One", db.NotDownloaded)
+			require.NoError(t, err)
+			item.FileURL = server.URL + "/episode.mp3"
+			require.NoError(t, testDB.Save(item).Error)
+
+			require.NoError(t, DownloadSingleEpisode(item.ID))
+
+			var saved db.PodcastItem
+			require.NoError(t, db.GetPodcastItemById(item.ID, &saved))
+			assert.Equal(t, filepath.Join(dataDir, tt.expectedFolder, "episode-one.mp3"), saved.DownloadPath)
+			assert.FileExists(t, saved.DownloadPath)
+		})
+	}
+}
+
+func TestSharedFolderOverrideKeepsFilesApart(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	dataDir := t.TempDir()
+	t.Setenv("DATA", dataDir)
+	server := newFeedServer(t, "audio")
+
+	var podcasts []*db.Podcast
+	var items []*db.PodcastItem
+	for _, title := range []string{"Gadget Hour", "Code Talk"} {
+		podcast, err := db.CreateTestPodcast(testDB, title)
+		require.NoError(t, err)
+		require.NoError(t, db.UpdatePodcastFolderOverride(podcast.ID, "Tech"))
+		podcast.FolderOverride = "Tech"
+		podcast.Image = server.URL + "/cover.jpg"
+		podcasts = append(podcasts, podcast)
+
+		// Both podcasts have an episode with the same title
+		item, err := db.CreateTestPodcastItem(testDB, podcast, "Episode One", db.NotDownloaded)
+		require.NoError(t, err)
+		item.FileURL = server.URL + "/episode.mp3"
+		require.NoError(t, testDB.Save(item).Error)
+		items = append(items, item)
+	}
+
+	var downloads []string
+	for _, item := range items {
+		require.NoError(t, DownloadSingleEpisode(item.ID))
+		var saved db.PodcastItem
+		require.NoError(t, db.GetPodcastItemById(item.ID, &saved))
+		downloads = append(downloads, saved.DownloadPath)
+	}
+
+	assert.Equal(t, filepath.Join(dataDir, "tech", "gadget-hour-episode-one.mp3"), downloads[0])
+	assert.Equal(t, filepath.Join(dataDir, "tech", "code-talk-episode-one.mp3"), downloads[1])
+
+	for _, podcast := range podcasts {
+		require.NoError(t, cachePodcastImage(podcast))
+		require.NoError(t, CreateNfoFile(podcast))
+	}
+	var first, second db.Podcast
+	require.NoError(t, db.GetPodcastById(podcasts[0].ID, &first))
+	require.NoError(t, db.GetPodcastById(podcasts[1].ID, &second))
+	assert.NotEqual(t, first.LocalImagePath, second.LocalImagePath)
+	assert.FileExists(t, first.LocalImagePath)
+	assert.FileExists(t, second.LocalImagePath)
+	assert.FileExists(t, filepath.Join(dataDir, "tech", "gadget-hour-album.nfo"))
+	assert.FileExists(t, filepath.Join(dataDir, "tech", "code-talk-album.nfo"))
+
+	t.Run("podcasts with the same title fall back to ids", func(t *testing.T) {
+		twin, err := db.CreateTestPodcast(testDB, "Gadget Hour")
+		require.NoError(t, err)
+		twin.FolderOverride = "Tech"
+		require.NoError(t, db.UpdatePodcastFolderOverride(twin.ID, "Tech"))
+		assert.Equal(t, twin.ID, getPodcastFilePrefix(twin))
+		assert.Equal(t, podcasts[0].ID, getPodcastFilePrefix(podcasts[0]))
+		assert.Equal(t, "code-talk", getPodcastFilePrefix(podcasts[1]))
+	})
+}
+
+func TestLibraryManifestRoundTrip(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	statuses := []db.DownloadStatus{db.Downloaded, db.NotDownloaded, db.Deleted}
+	for p, title := range []string{"First Podcast", "Second Podcast"} {
+		podcast, err := db.CreateTestPodcast(testDB, title)
+		require.NoError(t, err)
+		for i, status := range statuses {
+			item, err := db.CreateTestPodcastItem(testDB, podcast, fmt.Sprintf("%s Episode %d", title, i), status)
+			require.NoError(t, err)
+			item.PubDate = time.Date(2024, 1, 1+i, p, 0, 0, 0, time.UTC)
+			item.IsPlayed = i == 0
+			if status == db.Downloaded {
+				item.DownloadPath = fmt.Sprintf("/data/%s/episode-%d.mp3", title, i)
+			}
+			require.NoError(t, testDB.Save(item).Error)
+		}
+		// Feeds without GUIDs are matched by enclosure instead
+		for i := 0; i < 2; i++ {
+			item, err := db.CreateTestPodcastItem(testDB, podcast, fmt.Sprintf("%s Untracked %d", title, i), db.NotDownloaded)
+			require.NoError(t, err)
+			item.GUID = ""
+			item.FileURL = fmt.Sprintf("http://example.com/%s/untracked-%d.mp3", podcast.ID, i)
+			item.PubDate = time.Date(2023, 6, 1+i, p, 0, 0, 0, time.UTC)
+			require.NoError(t, testDB.Save(item).Error)
+		}
+	}
+
+	normalize := func(data []byte) model.LibraryManifest {
+		var manifest model.LibraryManifest
+		require.NoError(t, json.Unmarshal(data, &manifest))
+		manifest.ExportedAt = time.Time{}
+		sort.Slice(manifest.Podcasts, func(i, j int) bool {
+			return manifest.Podcasts[i].URL < manifest.Podcasts[j].URL
+		})
+		return manifest
+	}
+
+	exported, err := ExportLibraryManifest()
+	require.NoError(t, err)
+	original := normalize(exported)
+	assert.Equal(t, model.LibraryManifestVersion, original.Version)
+	require.Len(t, original.Podcasts, 2)
+	require.Len(t, original.Podcasts[0].Items, 5)
+	var firstPodcast db.Podcast
+	require.NoError(t, db.GetPodcastByURL(original.Podcasts[0].URL, &firstPodcast))
+	assert.Equal(t, firstPodcast.ID, original.Podcasts[0].ID)
+
+	require.NoError(t, testDB.Exec("DELETE FROM podcast_items").Error)
+	require.NoError(t, testDB.Exec("DELETE FROM podcasts").Error)
+
+	require.NoError(t, ImportLibraryManifest(exported))
+	reexported, err := ExportLibraryManifest()
+	require.NoError(t, err)
+	// Ids are restored too, so the export matches field for field
+	assert.Equal(t, original, normalize(reexported))
+
+	t.Run("importing again does not duplicate rows", func(t *testing.T) {
+		require.NoError(t, ImportLibraryManifest(exported))
+		var podcastCount, itemCount int64
+		testDB.Model(&db.Podcast{}).Count(&podcastCount)
+		testDB.Model(&db.PodcastItem{}).Count(&itemCount)
+		assert.Equal(t, int64(2), podcastCount)
+		assert.Equal(t, int64(10), itemCount)
+	})
+
+	t.Run("unknown version is rejected", func(t *testing.T) {
+		err := ImportLibraryManifest([]byte(`{"version": 99, "podcasts": []}`))
+		var versionErr *model.UnsupportedManifestVersionError
+		assert.ErrorAs(t, err, &versionErr)
+	})
+}
+
+func TestAddPodcastItemsFollowsArchiveLinks(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	archiveFeed := func(link string, items ...string) string {
+		return `<?xml version="1.0" encoding="UTF-8"?><rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel><title>Test Feed</title>` +
+			link + strings.Join(items, "") + `</channel></rss>`
+	}
+	pages := map[string]string{
+		"/feed": archiveFeed(`<atom:link rel="prev-archive" href="/archive/1"/>`,
+			testFeedItem("ep-3", "Episode 3", "Wed, 17 Jan 2024 10:00:00 GMT"),
+			testFeedItem("ep-2", "Episode 2", "Tue, 16 Jan 2024 10:00:00 GMT"),
+		),
+		"/archive/1": archiveFeed(`<atom:link rel="prev-archive" href="/feed"/>`,
+			testFeedItem("ep-2", "Episode 2", "Tue, 16 Jan 2024 10:00:00 GMT"),
+			testFeedItem("ep-1", "Episode 1", "Mon, 15 Jan 2024 10:00:00 GMT"),
+		),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+	defer server.Close()
+
+	countItems := func(podcast *db.Podcast) int {
+		var items []db.PodcastItem
+		require.NoError(t, db.GetAllPodcastItemsByPodcastId(podcast.ID, &items))
+		return len(items)
+	}
+
+	t.Run("only the first page without opting in", func(t *testing.T) {
+		podcast, err := db.CreateTestPodcast(testDB, "Not Following")
+		require.NoError(t, err)
+		podcast.URL = server.URL + "/feed"
+		require.NoError(t, testDB.Save(podcast).Error)
+
+		require.NoError(t, AddPodcastItems(podcast, false))
+		assert.Equal(t, 2, countItems(podcast))
+	})
+
+	t.Run("items from archive pages are imported once", func(t *testing.T) {
+		podcast, err := db.CreateTestPodcast(testDB, "Following")
+		require.NoError(t, err)
+		podcast.URL = server.URL + "/feed"
+		podcast.FollowArchiveLinks = true
+		require.NoError(t, testDB.Save(podcast).Error)
+
+		require.NoError(t, AddPodcastItems(podcast, false))
+		assert.Equal(t, 3, countItems(podcast))
+
+		var item db.PodcastItem
+		assert.NoError(t, db.GetPodcastItemByPodcastIdAndGUID(podcast.ID, "ep-1", &item))
+	})
+
+	t.Run("page limit is respected", func(t *testing.T) {
+		setting := db.GetOrCreateSetting()
+		setting.MaxArchivePages = 0
+		require.NoError(t, db.UpdateSettings(setting))
+
+		podcast, err := db.CreateTestPodcast(testDB, "Limited")
+		require.NoError(t, err)
+		podcast.URL = server.URL + "/feed"
+		podcast.FollowArchiveLinks = true
+		require.NoError(t, testDB.Save(podcast).Error)
+
+		require.NoError(t, AddPodcastItems(podcast, false))
+		assert.Equal(t, 2, countItems(podcast))
+	})
+}
+
+func TestAddPodcastItemsFollowsFeedRedirects(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	feed := testFeed(testFeedItem("ep-1", "Episode 1", "Mon, 15 Jan 2024 10:00:00 GMT"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/feed", http.StatusMovedPermanently)
+		case "/moved-308":
+			http.Redirect(w, r, "/feed", http.StatusPermanentRedirect)
+		case "/temporary":
+			http.Redirect(w, r, "/feed", http.StatusFound)
+		case "/temporary-307":
+			http.Redirect(w, r, "/feed", http.StatusTemporaryRedirect)
+		case "/moved-then-temporary":
+			http.Redirect(w, r, "/temporary", http.StatusMovedPermanently)
+		case "/taken":
+			http.Redirect(w, r, "/existing", http.StatusMovedPermanently)
+		case "/taken-variant":
+			http.Redirect(w, r, "/existing-variant", http.StatusMovedPermanently)
+		case "/feed", "/existing", "/existing-variant":
+			fmt.Fprint(w, feed)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	existing, err := db.CreateTestPodcast(testDB, "Existing")
+	require.NoError(t, err)
+	existing.URL = server.URL + "/existing"
+	require.NoError(t, testDB.Save(existing).Error)
+
+	// Subscribed under another spelling of the same feed
+	variant, err := db.CreateTestPodcast(testDB, "Existing Variant")
+	require.NoError(t, err)
+	variant.URL = strings.Replace(server.URL, "http://", "https://www.", 1) + "/existing-variant/"
+	require.NoError(t, testDB.Save(variant).Error)
+
+	tests := []struct {
+		name        string
+		path        string
+		expectedURL string
+	}{
+		{"301 is persisted", "/moved", server.URL + "/feed"},
+		{"308 is persisted", "/moved-308", server.URL + "/feed"},
+		{"302 is not persisted", "/temporary", server.URL + "/temporary"},
+		{"307 is not persisted", "/temporary-307", server.URL + "/temporary-307"},
+		{"mixed chain is not persisted", "/moved-then-temporary", server.URL + "/moved-then-temporary"},
+		{"collision with existing subscription", "/taken", server.URL + "/taken"},
+		{"collision with a variant of an existing subscription", "/taken-variant", server.URL + "/taken-variant"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			podcast, err := db.CreateTestPodcast(testDB, tt.name)
+			require.NoError(t, err)
+			podcast.URL = server.URL + tt.path
+			require.NoError(t, testDB.Save(podcast).Error)
+
+			require.NoError(t, AddPodcastItems(podcast, false))
+
+			var saved db.Podcast
+			require.NoError(t, db.GetPodcastById(podcast.ID, &saved))
+			assert.Equal(t, tt.expectedURL, saved.URL)
+
+			var item db.PodcastItem
+			assert.NoError(t, db.GetPodcastItemByPodcastIdAndGUID(podcast.ID, "ep-1", &item))
+
+			// Free the new location for the next case
+			require.NoError(t, testDB.Delete(&db.Podcast{}, "id=?", podcast.ID).Error)
+		})
+	}
+}
+
+func TestAddPodcastMatchesFeedURLVariants(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	podcast, err := db.CreateTestPodcast(testDB, "Existing")
+	require.NoError(t, err)
+	podcast.URL = "https://example.com/feed/?format=rss"
+	require.NoError(t, testDB.Save(podcast).Error)
+
+	for _, url := range []string{
+		"https://example.com/feed/?format=rss",
+		"http://example.com/feed?format=rss",
+		"https://www.Example.com/feed/?format=rss",
+	} {
+		_, err := AddPodcast(url)
+		var existsErr *model.PodcastAlreadyExistsError
+		assert.ErrorAs(t, err, &existsErr, url)
+	}
+
+	assert.NotEqual(t, feedURLKey("https://example.com/feed?format=rss"), feedURLKey("https://example.com/feed?format=atom"))
+	assert.NotEqual(t, feedURLKey("https://example.com/feed"), feedURLKey("https://example.com/other"))
+}
+
+func TestDownloadWritesID3Tags(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	dataDir := t.TempDir()
+	t.Setenv("DATA", dataDir)
+
+	audio := make([]byte, 417)
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x64})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(audio)
+	}))
+	defer server.Close()
+
+	cover := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	coverPath := filepath.Join(dataDir, "cover.png")
+	require.NoError(t, ioutil.WriteFile(coverPath, cover, 0644))
+
+	podcast, err := db.CreateTestPodcast(testDB, "Tagged Podcast")
+	require.NoError(t, err)
+	podcast.LocalImagePath = coverPath
+	require.NoError(t, testDB.Save(podcast).Error)
+
+	setting := db.GetOrCreateSetting()
+	setting.WriteID3Tags = true
+	require.NoError(t, db.UpdateSettings(setting))
+
+	download := func(title string, fileName string) string {
+		item, err := db.CreateTestPodcastItem(testDB, podcast, title, db.NotDownloaded)
+		require.NoError(t, err)
+		item.FileURL = server.URL + "/" + fileName
+		item.PubDate = time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+		require.NoError(t, testDB.Save(item).Error)
+
+		require.NoError(t, DownloadSingleEpisode(item.ID))
+
+		var saved db.PodcastItem
+		require.NoError(t, db.GetPodcastItemById(item.ID, &saved))
+		assert.Equal(t, db.Downloaded, saved.DownloadStatus)
+		return saved.DownloadPath
+	}
+
+	t.Run("mp3 files are tagged", func(t *testing.T) {
+		path := download("First Episode", "first.mp3")
+
+		tag, err := id3.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "First Episode", tag.Title)
+		assert.Equal(t, "Test Author", tag.Artist)
+		assert.Equal(t, "Tagged Podcast", tag.Album)
+		assert.Equal(t, "1", tag.Track)
+		assert.Equal(t, "2023", tag.Year)
+		assert.Equal(t, cover, tag.Picture)
+	})
+
+	t.Run("other files are left alone", func(t *testing.T) {
+		path := download("Second Episode", "second.m4a")
+
+		content, err := ioutil.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, audio, content)
+	})
+}
+
+func TestDownloadAttemptsAndLastError(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	t.Setenv("DATA", t.TempDir())
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("audio"))
+	}))
+	defer server.Close()
+
+	podcast, err := db.CreateTestPodcast(testDB, "Unreliable Podcast")
+	require.NoError(t, err)
+	item, err := db.CreateTestPodcastItem(testDB, podcast, "Episode", db.NotDownloaded)
+	require.NoError(t, err)
+	item.FileURL = server.URL + "/episode.mp3"
+	require.NoError(t, testDB.Save(item).Error)
+
+	var saved db.PodcastItem
+	for attempt := 1; attempt <= 2; attempt++ {
+		assert.Error(t, DownloadSingleEpisode(item.ID))
+
+		require.NoError(t, db.GetPodcastItemById(item.ID, &saved))
+		assert.Equal(t, attempt, saved.DownloadAttempts)
+		assert.Contains(t, saved.LastDownloadError, "503")
+		assert.Equal(t, db.NotDownloaded, saved.DownloadStatus)
+	}
+
+	require.NoError(t, DownloadSingleEpisode(item.ID))
+
+	require.NoError(t, db.GetPodcastItemById(item.ID, &saved))
+	assert.Equal(t, db.Downloaded, saved.DownloadStatus)
+	assert.Equal(t, 0, saved.DownloadAttempts)
+	assert.Empty(t, saved.LastDownloadError)
+}
+
+func TestResetAllPodcastItemsDeletesFiles(t *testing.T) {
+	testDB, err := db.SetupTestDB()
+	require.NoError(t, err)
+	defer db.TeardownTestDB(testDB)
+
+	podcast, err := db.CreateTestPodcast(testDB, "Test Podcast")
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "downloaded.mp3")
+	require.NoError(t, ioutil.WriteFile(file, []byte("audio"), 0644))
+	item, err := db.CreateTestPodcastItem(testDB, podcast, "Downloaded", db.Downloaded)
+	require.NoError(t, err)
+	item.DownloadPath = file
+	require.NoError(t, testDB.Save(item).Error)
+
+	count, err := ResetAllPodcastItems(podcast.ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+	assert.FileExists(t, file)
+
+	count, err = ResetAllPodcastItems(podcast.ID, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.NoFileExists(t, file)
+
+	count, err = QueueAllPodcastItems(podcast.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}